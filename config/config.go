@@ -1,29 +1,16 @@
 package config
 
 import (
-	"os"
-
-	"github.com/happyxhw/gopkg/logger"
 	"github.com/spf13/viper"
 )
 
-// InitConfig init config
+// InitConfig load the layered config of configPath into viper, see Options,
+// ENV may name any environment, e.g. ENV=staging loads staging.yml,
+// the environment variables do not override the keys
 func InitConfig(configPath string) error {
-	if os.Getenv("ENV") == "DEV" {
-		viper.SetConfigName("dev")
-	} else if os.Getenv("ENV") == "TEST" {
-		viper.SetConfigName("test")
-	} else if os.Getenv("ENV") == "PROD" {
-		viper.SetConfigName("prod")
-	} else {
-		logger.Fatal("wrong env not in [DEV, TEST, PROD]")
-	}
-	viper.AddConfigPath(configPath)
-	viper.SetConfigType("yaml")
-
-	if err := viper.ReadInConfig(); err != nil {
+	v, _, err := (&Options{Dir: configPath, NoEnv: true}).viper(nil)
+	if err != nil {
 		return err
 	}
-
-	return nil
+	return viper.MergeConfigMap(v.AllSettings())
 }
@@ -1,11 +1,26 @@
 package config
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v7"
+	"github.com/happyxhw/gopkg/dbgo"
+	ginServer "github.com/happyxhw/gopkg/gin"
+	"github.com/happyxhw/gopkg/goredis"
+	"github.com/happyxhw/gopkg/logger"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"go.uber.org/zap/zapcore"
 )
 
 func TestSetupConfig(t *testing.T) {
@@ -16,4 +31,412 @@ func TestSetupConfig(t *testing.T) {
 	}
 
 	fmt.Println(viper.Get("db"))
+
+	_ = os.Setenv("ENV", "CANARY")
+	defer os.Unsetenv("ENV")
+	if err := InitConfig("."); err == nil {
+		t.Error("missing canary.yml loaded")
+	}
+}
+
+type appConfig struct {
+	DB    dbgo.Config
+	Redis goredis.Config
+	HTTP  ginServer.Config
+	Log   logger.Config
+}
+
+func writeConfig(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "config*.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.Remove(f.Name())
+	})
+	_, _ = f.WriteString(content)
+	_ = f.Close()
+	return f.Name()
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConfig(t, `
+db:
+  user: root
+  db: test
+  max_idle_conns: 10
+redis:
+  db: 1
+log:
+  level: debug
+  sinks:
+    - type: file
+      filename: app.log
+`)
+	var c appConfig
+	if err := Load(path, &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.DB.Host != "127.0.0.1" || c.DB.MaxIdleConns != 10 || c.Redis.Host != "127.0.0.1:6379" {
+		t.Errorf("defaults not applied: %+v %+v", c.DB, c.Redis)
+	}
+	if c.HTTP.Addr != ":8080" || c.HTTP.Mode != "release" {
+		t.Errorf("defaults not applied: %+v", c.HTTP)
+	}
+	if c.Log.Sinks[0].Filename != "app.log" {
+		t.Errorf("sinks: %+v", c.Log.Sinks)
+	}
+
+	path = writeConfig(t, `
+db:
+  port: 70000
+redis:
+  db: 16
+http:
+  mode: dev
+log:
+  sinks:
+    - type: kafka
+`)
+	err := Load(path, &appConfig{})
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("want a ValidationError, got %v", err)
+	}
+	want := map[string]string{
+		"db.port":           "max",
+		"redis.db":          "max",
+		"http.mode":         "oneof",
+		"log.sinks[0].type": "oneof",
+	}
+	for _, f := range verr.Fields {
+		if want[f.Field] == f.Rule {
+			delete(want, f.Field)
+		}
+	}
+	if len(want) > 0 {
+		t.Errorf("missing errors %v in %v", want, err)
+	}
+
+	if err := Validate(&logger.Config{Level: "INFO", Encoder: "JSON"}); err != nil {
+		t.Errorf("upper case level: %v", err)
+	}
+	if err := Validate(&logger.Config{Level: "verbose"}); err == nil || !strings.Contains(err.Error(), "level must be one of") {
+		t.Errorf("unknown level: %v", err)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	path := writeConfig(t, "db:\n  user: root\n  db: test\nlog:\n  level: info\n")
+	var c appConfig
+	w, err := NewWatcher(path, &c, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	changed := make(chan [2]interface{}, 1)
+	w.OnChange("db.user", func(old, new interface{}) {
+		changed <- [2]interface{}{old, new}
+	})
+
+	_ = ioutil.WriteFile(path, []byte("db:\n  user: admin\n  db: test\nlog:\n  level: debug\n"), 0600)
+	select {
+	case v := <-changed:
+		if v[0] != "root" || v[1] != "admin" {
+			t.Errorf("old %v, new %v", v[0], v[1])
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no change")
+	}
+	if u := w.Current().(*appConfig).DB.User; u != "admin" {
+		t.Errorf("current user %s", u)
+	}
+	if l := logger.Level(); l != zapcore.InfoLevel {
+		t.Errorf("logger level %s changed by a watcher other than the default one", l)
+	}
+
+	// invalid content is not applied
+	_ = ioutil.WriteFile(path, []byte("db:\n  user: guest\n  port: 70000\n"), 0600)
+	time.Sleep(200 * time.Millisecond)
+	if err := w.Reload(); err == nil {
+		t.Error("invalid config reloaded")
+	}
+	if u := w.Current().(*appConfig).DB.User; u != "admin" {
+		t.Errorf("current user %s after an invalid change", u)
+	}
+	select {
+	case v := <-changed:
+		t.Errorf("change %v notified for an invalid config", v)
+	default:
+	}
+}
+
+func TestWatch(t *testing.T) {
+	path := writeConfig(t, "db:\n  user: root\n  db: test\nlog:\n  level: info\n")
+	var c appConfig
+	if err := Watch(path, &c); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = defaultWatcher.Close()
+		_ = defaultWatcher.Close()
+	}()
+	defer logger.SetLevel(zapcore.InfoLevel)
+
+	_ = ioutil.WriteFile(path, []byte("db:\n  user: admin\n  db: test\nlog:\n  level: debug\n"), 0600)
+	deadline := time.Now().Add(2 * time.Second)
+	for logger.Level() != zapcore.DebugLevel && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if l := logger.Level(); l != zapcore.DebugLevel {
+		t.Errorf("logger level %s", l)
+	}
+	if u := Current().(*appConfig).DB.User; u != "admin" {
+		t.Errorf("current user %s", u)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"config.yml":  "db:\n  user: root\n  db: app\n  port: 3306\nhttp:\n  addr: :80\n",
+		"staging.yml": "db:\n  host: staging-db\n  port: 3307\n",
+		"local.json":  `{"db": {"port": 3308}}`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = os.Setenv("APP_DB_DB", "app_staging")
+	_ = os.Setenv("APP_REDIS_POOL_SIZE", "20")
+	defer os.Unsetenv("APP_DB_DB")
+	defer os.Unsetenv("APP_REDIS_POOL_SIZE")
+
+	var c appConfig
+	o := Options{Dir: dir, Env: "staging", EnvPrefix: "APP"}
+	if err := o.Load(&c); err != nil {
+		t.Fatal(err)
+	}
+	if c.DB.User != "root" || c.DB.Host != "staging-db" || c.DB.Port != 3308 || c.DB.DB != "app_staging" {
+		t.Errorf("db %+v", c.DB)
+	}
+	if c.HTTP.Addr != ":80" || c.Redis.PoolSize != 20 {
+		t.Errorf("http %+v, redis %+v", c.HTTP, c.Redis)
+	}
+
+	o.Env = "canary"
+	if err := o.Load(&appConfig{}); err == nil {
+		t.Error("missing environment overlay loaded")
+	}
+	if err := (&Options{Dir: filepath.Join(dir, "none")}).Load(&appConfig{}); err == nil {
+		t.Error("empty dir loaded")
+	}
+}
+
+func TestEnvOverrides(t *testing.T) {
+	secret := writeConfig(t, "s3cret\n")
+	path := writeConfig(t, `
+db:
+  user: ${TEST_DB_USER}
+  db: ${TEST_DB_NAME:-app}
+  host: $${literal}
+redis:
+  host: ${TEST_REDIS_HOST}:6379
+`)
+	env := map[string]string{
+		"TEST_DB_USER":         "root",
+		"TEST_REDIS_HOST":      "cache",
+		"APP_DB_PASSWORD_FILE": secret,
+		"APP_REDIS_PASSWORD":   "plain",
+		"JWT_SECRET":           "jwt",
+		"APP_REDIS_POOL_SIZE":  "8",
+	}
+	for k, v := range env {
+		_ = os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	var c struct {
+		App    appConfig `mapstructure:",squash"`
+		JwtKey string    `mapstructure:"jwt_key"`
+	}
+	o := Options{Files: []string{path}, EnvPrefix: "APP", EnvKeys: map[string]string{"jwt_key": "JWT_SECRET"}}
+	if err := o.Load(&c); err != nil {
+		t.Fatal(err)
+	}
+	if db := c.App.DB; db.User != "root" || db.DB != "app" || db.Host != "${literal}" || db.Password != "s3cret" {
+		t.Errorf("db %+v", db)
+	}
+	if r := c.App.Redis; r.Host != "cache:6379" || r.Password != "plain" || r.PoolSize != 8 || c.JwtKey != "jwt" {
+		t.Errorf("redis %+v, jwt %q", r, c.JwtKey)
+	}
+
+	// without prefix the ordinary variables do not override the keys
+	_ = os.Setenv("DB_DB", "other")
+	defer os.Unsetenv("DB_DB")
+	var plain appConfig
+	if err := (&Options{Files: []string{path}}).Load(&plain); err != nil || plain.DB.DB != "app" {
+		t.Errorf("db %q: %v", plain.DB.DB, err)
+	}
+
+	_ = os.Setenv("APP_REDIS_DB_FILE", secret+".missing")
+	defer os.Unsetenv("APP_REDIS_DB_FILE")
+	if err := o.Load(&appConfig{}); err == nil {
+		t.Error("missing secret file loaded")
+	}
+	_ = os.Unsetenv("TEST_DB_USER")
+	if err := (&Options{Files: []string{path}, NoEnv: true}).Load(&appConfig{}); err == nil {
+		t.Error("unset variable interpolated")
+	}
+}
+
+func TestBindFlags(t *testing.T) {
+	path := writeConfig(t, "db:\n  user: root\n  db: app\n  max_open_conns: 10\nhttp:\n  addr: :9000\n")
+	_ = os.Setenv("APP_HTTP_ADDR", ":9001")
+	defer os.Unsetenv("APP_HTTP_ADDR")
+
+	var c appConfig
+	fs := pflag.NewFlagSet("app", pflag.ContinueOnError)
+	if err := BindFlags(fs, &c); err != nil {
+		t.Fatal(err)
+	}
+	usage := fs.FlagUsages()
+	for _, want := range []string{"--db.max-open-conns int", "max open connections", `--http.addr string`, `(default ":8080")`, "--log.redact.keys strings"} {
+		if !strings.Contains(usage, want) {
+			t.Errorf("usage has no %q:\n%s", want, usage)
+		}
+	}
+	if err := fs.Parse([]string{"--http.addr", ":9002", "--db.max-open-conns=50", "--log.caller"}); err != nil {
+		t.Fatal(err)
+	}
+	o := Options{Files: []string{path}, EnvPrefix: "APP", Flags: fs}
+	if err := o.Load(&c); err != nil {
+		t.Fatal(err)
+	}
+	if c.HTTP.Addr != ":9002" || c.DB.MaxOpenConns != 50 || !c.Log.Caller || c.DB.User != "root" || c.HTTP.Mode != "release" {
+		t.Errorf("config %+v", c)
+	}
+	if src := o.Sources()["http.addr"]; src != "flag:--http.addr" {
+		t.Errorf("http.addr source %q", src)
+	}
+	if err := BindFlags(fs, &c); err == nil {
+		t.Error("flags bound twice")
+	}
+}
+
+func TestDump(t *testing.T) {
+	path := writeConfig(t, "db:\n  user: root\n  password: s3cret\n  db: app\nlog:\n  sinks:\n    - filename: app.log\n  levels:\n    dbgo: debug\n")
+	_ = os.Setenv("APP_REDIS_PASSWORD", "redis-pass")
+	defer os.Unsetenv("APP_REDIS_PASSWORD")
+	var c struct {
+		App    appConfig `mapstructure:",squash"`
+		JwtKey string    `mapstructure:"jwt_key"`
+		Sign   string    `secret:"true"`
+	}
+	c.JwtKey, c.Sign = "jwt", "sign"
+	o := Options{Files: []string{path}, EnvPrefix: "APP"}
+	if err := o.Load(&c); err != nil {
+		t.Fatal(err)
+	}
+	out, err := o.Dump(&c, YAMLFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log("\n" + string(out))
+	for _, s := range []string{"s3cret", "redis-pass", "jwt\n", "sign\n"} {
+		if strings.Contains(string(out), s) {
+			t.Errorf("%q not masked", s)
+		}
+	}
+	for _, s := range []string{
+		"user: root # file:" + path,
+		"password: '******' # env:APP_REDIS_PASSWORD",
+		"host: 127.0.0.1:6379 # default",
+	} {
+		if !strings.Contains(string(out), s) {
+			t.Errorf("missing %q", s)
+		}
+	}
+	out, err = o.Dump(&c, JSONFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]map[string]interface{}
+	if err := json.Unmarshal(out, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["sources"]["redis.password"] != "env:APP_REDIS_PASSWORD" {
+		t.Errorf("sources %v", m["sources"])
+	}
+
+	old := c
+	c.App.DB.Password, c.App.DB.Port = "changed", 3307
+	changes := diff(&old, &c)
+	want := []string{`db.password: "******" -> "******"`, "db.port: 0 -> 3307"}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("diff %v", changes)
+	}
+}
+
+func TestRedisSource(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Close()
+	mr.HSet("app:config", "redis.pool_size", "20")
+	mr.HSet("app:config", "log.level", "warn")
+
+	src, err := NewRedisSource(&goredis.Config{Host: mr.Addr()}, "app:config", 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writeConfig(t, "db:\n  user: root\n  db: app\nredis:\n  pool_size: 10\nlog:\n  level: info\n")
+	var c appConfig
+	o := Options{Files: []string{path}, Overlays: []Source{src}, NoEnv: true, Debounce: 10 * time.Millisecond}
+	w, err := o.Watch(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	defer logger.SetLevel(zapcore.InfoLevel)
+	if c.Redis.PoolSize != 20 || c.DB.User != "root" || logger.Level() != zapcore.InfoLevel {
+		t.Errorf("redis %+v, db %+v", c.Redis, c.DB)
+	}
+	if s := o.Sources()["redis.pool_size"]; s != "redis:app:config" {
+		t.Errorf("source %s", s)
+	}
+
+	changed := make(chan interface{}, 1)
+	w.OnChange("redis.pool_size", func(_, new interface{}) {
+		changed <- new
+	})
+	mr.HSet("app:config", "redis.pool_size", "30")
+	select {
+	case v := <-changed:
+		if v != "30" {
+			t.Errorf("new value %v", v)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no change")
+	}
+	if n := w.Current().(*appConfig).Redis.PoolSize; n != 30 {
+		t.Errorf("pool size %d", n)
+	}
+
+	// a json string key
+	mr.Set("app:json", `{"HTTP": {"Mode": "debug"}}`)
+	var c2 appConfig
+	o2 := Options{Files: []string{path}, Overlays: []Source{NewRedisClientSource(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "app:json", 0)}, NoEnv: true}
+	if err := o2.Load(&c2); err != nil {
+		t.Fatal(err)
+	}
+	if c2.HTTP.Mode != "debug" {
+		t.Errorf("http %+v", c2.HTTP)
+	}
 }
@@ -0,0 +1,269 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/happyxhw/gopkg/logger"
+	"gopkg.in/yaml.v3"
+)
+
+// dump formats
+const (
+	YAMLFormat = "yaml"
+	JSONFormat = "json"
+)
+
+// value sources reported by Dump, file, env and flag are followed by the path or the variable, e.g. env:APP_DB_HOST
+const (
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceDefault = "default"
+)
+
+const secretMask = "******"
+
+// SecretKeys names masked by Dump besides the fields tagged secret:"true",
+// matched as a whole or as the `_` suffix, e.g. key matches jwt_key
+var SecretKeys = []string{"password", "passwd", "pwd", "secret", "token", "key", "dsn", "credentials"}
+
+// Dump render out, a pointer to a config struct, as yaml or json with the secrets masked
+func Dump(out interface{}, format string) ([]byte, error) {
+	return dump(out, format, nil)
+}
+
+// Dump render out like Dump with the source of each value of the last Load,
+// as a line comment in yaml, in a sources object in json
+func (o *Options) Dump(out interface{}, format string) ([]byte, error) {
+	return dump(out, format, o.Sources())
+}
+
+// Sources return the source of each key of the last Load, e.g. db.host: env:APP_DB_HOST
+func (o *Options) Sources() map[string]string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return copySources(o.sources)
+}
+
+func (o *Options) setSources(sources map[string]string) {
+	o.mu.Lock()
+	o.sources = sources
+	o.mu.Unlock()
+}
+
+func copySources(sources map[string]string) map[string]string {
+	if sources == nil {
+		return nil
+	}
+	cp := make(map[string]string, len(sources))
+	for k, v := range sources {
+		cp[k] = v
+	}
+	return cp
+}
+
+func dump(out interface{}, format string, sources map[string]string) ([]byte, error) {
+	m := masked(out)
+	switch strings.ToLower(format) {
+	case JSONFormat:
+		if sources == nil {
+			return json.MarshalIndent(m, "", "  ")
+		}
+		return json.MarshalIndent(map[string]interface{}{"config": m, "sources": sources}, "", "  ")
+	case YAMLFormat, "yml", "":
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(yamlNode(m, "", sources)); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("config: unknown dump format %q", format)
+}
+
+// masked convert out to nested maps keyed like the config files with the secrets masked
+func masked(out interface{}) map[string]interface{} {
+	r, _ := logger.NewRedactor(&logger.RedactConfig{Keys: SecretKeys, Mask: secretMask})
+	m, _ := plain(reflect.ValueOf(out), false, r).(map[string]interface{})
+	return m
+}
+
+// unmasked convert out like masked without masking
+func unmasked(out interface{}) map[string]interface{} {
+	m, _ := plain(reflect.ValueOf(out), false, nil).(map[string]interface{})
+	return m
+}
+
+func isSecret(r *logger.Redactor, key string) bool {
+	return r != nil && r.IsSensitive(key)
+}
+
+func plain(v reflect.Value, secret bool, r *logger.Redactor) interface{} {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if secret && !v.IsZero() {
+		return secretMask
+	}
+	if d, ok := v.Interface().(time.Duration); ok {
+		return d.String()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		if t, ok := v.Interface().(time.Time); ok {
+			return t.Format(time.RFC3339)
+		}
+		m := make(map[string]interface{})
+		plainStruct(v, m, r)
+		return m
+	case reflect.Map:
+		m := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			k := fmt.Sprint(iter.Key().Interface())
+			m[k] = plain(iter.Value(), isSecret(r, k), r)
+		}
+		return m
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return string(v.Bytes())
+		}
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			list[i] = plain(v.Index(i), false, r)
+		}
+		return list
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return v.Interface()
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+func plainStruct(v reflect.Value, m map[string]interface{}, r *logger.Redactor) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Tag.Get("mapstructure") == "-" {
+			continue
+		}
+		fv := v.Field(i)
+		if f.Anonymous || strings.Contains(f.Tag.Get("mapstructure"), "squash") {
+			for fv.Kind() == reflect.Ptr && !fv.IsNil() {
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				plainStruct(fv, m, r)
+			}
+			continue
+		}
+		name := keyName(f)
+		m[name] = plain(fv, (r != nil && f.Tag.Get("secret") == "true") || isSecret(r, name), r)
+	}
+}
+
+// yamlNode build the yaml of v with the sources as line comments
+func yamlNode(v interface{}, key string, sources map[string]string) *yaml.Node {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		n := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		names := make([]string, 0, len(v))
+		for k := range v {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			n.Content = append(n.Content,
+				&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k},
+				yamlNode(v[k], joinKey(key, k), sources),
+			)
+		}
+		return n
+	case []interface{}:
+		n := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		flow := true
+		for i, e := range v {
+			child := yamlNode(e, fmt.Sprintf("%s[%d]", key, i), sources)
+			flow = flow && child.Kind == yaml.ScalarNode
+			n.Content = append(n.Content, child)
+		}
+		if flow {
+			n.Style = yaml.FlowStyle
+			n.LineComment = sources[key]
+		}
+		return n
+	}
+	n := scalarNode(v)
+	n.LineComment = sources[key]
+	return n
+}
+
+func scalarNode(v interface{}) *yaml.Node {
+	n := &yaml.Node{Kind: yaml.ScalarNode}
+	switch v := v.(type) {
+	case nil:
+		n.Tag, n.Value = "!!null", "null"
+	case string:
+		n.Tag, n.Value = "!!str", v
+	case bool:
+		n.Tag, n.Value = "!!bool", strconv.FormatBool(v)
+	case float32, float64:
+		n.Tag, n.Value = "!!float", fmt.Sprint(v)
+	default:
+		n.Tag, n.Value = "!!int", fmt.Sprint(v)
+	}
+	return n
+}
+
+// flatten return the leaf values of m by dotted key
+func flatten(m map[string]interface{}) map[string]string {
+	flat := make(map[string]string)
+	var walk func(key string, v interface{})
+	walk = func(key string, v interface{}) {
+		if sub, ok := v.(map[string]interface{}); ok && len(sub) > 0 {
+			for k, e := range sub {
+				walk(joinKey(key, k), e)
+			}
+			return
+		}
+		b, _ := json.Marshal(v)
+		flat[key] = string(b)
+	}
+	for k, v := range m {
+		walk(k, v)
+	}
+	return flat
+}
+
+// diff list the changed keys of two configs with their masked values, e.g. db.port: 3306 -> 3307
+func diff(old, new interface{}) []string {
+	o, n := flatten(unmasked(old)), flatten(unmasked(new))
+	om, nm := flatten(masked(old)), flatten(masked(new))
+	var changes []string
+	for k, v := range n {
+		if ov, ok := o[k]; !ok {
+			changes = append(changes, k+": added "+nm[k])
+		} else if ov != v {
+			changes = append(changes, k+": "+om[k]+" -> "+nm[k])
+		}
+	}
+	for k := range o {
+		if _, ok := n[k]; !ok {
+			changes = append(changes, k+": removed "+om[k])
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// SourceFlag prefix of the command line flags in Dump, followed by the flag, e.g. flag:--http.addr
+const SourceFlag = "flag"
+
+// flagKeyAnnotation annotation of the generated flags holding their config key
+const flagKeyAnnotation = "config_key"
+
+// BindFlags define a flag on fs for each scalar field of out, a pointer to a config struct,
+// named after its key with - for _, e.g. --http.addr, --db.max-open-conns.
+// The default tag is the default of the flag and the desc tag its usage, both shown by --help:
+//
+//	type Config struct {
+//		Addr string `mapstructure:"addr" default:":8080" desc:"listen address"`
+//	}
+//
+// Set Options.Flags to fs to apply the flags set on the command line over every other layer.
+// Maps and slices of structs have no flag.
+func BindFlags(fs *pflag.FlagSet, out interface{}) error {
+	var err error
+	walkFields(reflect.TypeOf(out), "", func(key string, f reflect.StructField) {
+		if err == nil {
+			err = bindFlag(fs, key, f)
+		}
+	})
+	return err
+}
+
+// flagName the flag of key, e.g. db.max-open-conns for db.max_open_conns
+func flagName(key string) string {
+	return strings.ReplaceAll(key, "_", "-")
+}
+
+func bindFlag(fs *pflag.FlagSet, key string, f reflect.StructField) error {
+	name := flagName(key)
+	if fs.Lookup(name) != nil {
+		return fmt.Errorf("config: flag --%s already defined", name)
+	}
+	t := f.Type
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	def := reflect.New(t).Elem()
+	if s, ok := f.Tag.Lookup("default"); ok {
+		if err := setValue(def, s); err != nil {
+			return fmt.Errorf("config: %s: invalid default %q: %w", key, s, err)
+		}
+	}
+	usage := f.Tag.Get("desc")
+	if t == reflect.TypeOf(time.Duration(0)) {
+		fs.Duration(name, time.Duration(def.Int()), usage)
+		return fs.SetAnnotation(name, flagKeyAnnotation, []string{key})
+	}
+	switch t.Kind() {
+	case reflect.String:
+		fs.String(name, def.String(), usage)
+	case reflect.Bool:
+		fs.Bool(name, def.Bool(), usage)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		fs.Int64(name, def.Int(), usage)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		fs.Uint64(name, def.Uint(), usage)
+	case reflect.Float32, reflect.Float64:
+		fs.Float64(name, def.Float(), usage)
+	case reflect.Slice:
+		if t.Elem().Kind() != reflect.String {
+			return nil
+		}
+		fs.StringSlice(name, def.Convert(reflect.TypeOf([]string(nil))).Interface().([]string), usage)
+	default:
+		return nil
+	}
+	return fs.SetAnnotation(name, flagKeyAnnotation, []string{key})
+}
+
+// applyFlags set the keys of the generated flags set on the command line
+func (o *Options) applyFlags(settings map[string]interface{}, sources map[string]string) error {
+	var err error
+	o.Flags.Visit(func(f *pflag.Flag) {
+		key := f.Annotations[flagKeyAnnotation]
+		if len(key) == 0 || err != nil {
+			return
+		}
+		var value interface{} = f.Value.String()
+		if f.Value.Type() == "stringSlice" {
+			value, err = o.Flags.GetStringSlice(f.Name)
+		}
+		set(settings, key[0], value)
+		sources[key[0]] = SourceFlag + ":--" + f.Name
+	})
+	return err
+}
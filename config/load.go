@@ -0,0 +1,270 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/spf13/viper"
+)
+
+var validate = newValidator()
+
+// FieldError an invalid config field
+type FieldError struct {
+	// Field path of the field, e.g. db.port
+	Field string
+	// Rule failed rule, e.g. required, max, default
+	Rule string
+	// Param of the rule, e.g. 65535
+	Param string
+}
+
+func (e FieldError) Error() string {
+	switch e.Rule {
+	case "required":
+		return e.Field + " is required"
+	case "min", "gte":
+		return e.Field + " must be at least " + e.Param
+	case "max", "lte":
+		return e.Field + " must be at most " + e.Param
+	case "oneof", "oneofci":
+		return e.Field + " must be one of [" + e.Param + "]"
+	case "default":
+		return e.Field + " has an invalid default " + strconv.Quote(e.Param)
+	}
+	return fmt.Sprintf("%s failed on %s=%s", e.Field, e.Rule, e.Param)
+}
+
+// ValidationError all the invalid fields of a config
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		msgs = append(msgs, f.Error())
+	}
+	return "config: " + strings.Join(msgs, "; ")
+}
+
+// Load read the config file at path into out, a pointer to a struct, e.g.
+//
+//	type AppConfig struct {
+//		DB    dbgo.Config
+//		Redis goredis.Config
+//		HTTP  gin.Config
+//		Log   logger.Config
+//	}
+//
+//	var c AppConfig
+//	err := config.Load("config/dev.yml", &c)
+//
+// zero fields are set to their `default:"..."` tag, then the `validate:"..."` tags are checked,
+// e.g. required, min=1, max=65535, oneof=debug release, oneofci=debug info for a case insensitive oneof,
+// the invalid fields are returned together as a *ValidationError
+func Load(path string, out interface{}) error {
+	return (&Options{Files: []string{path}, NoEnv: true}).Load(out)
+}
+
+func read(path string) (*viper.Viper, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Decode unmarshal v into out, apply the defaults and validate it like Load
+func Decode(v *viper.Viper, out interface{}) error {
+	_, err := decode(v, out)
+	return err
+}
+
+// decode return the keys set by default tags
+func decode(v *viper.Viper, out interface{}) ([]string, error) {
+	if err := v.Unmarshal(out); err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	return validateStruct(out)
+}
+
+// Validate apply the default tags to the zero fields of out then check its validate tags
+func Validate(out interface{}) error {
+	_, err := validateStruct(out)
+	return err
+}
+
+// validateStruct return the keys set by default tags
+func validateStruct(out interface{}) ([]string, error) {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config: %T is not a pointer to a struct", out)
+	}
+	d := defaulter{}
+	d.setDefaults(rv.Elem(), "")
+	errs := d.errs
+	if err := validate.Struct(out); err != nil {
+		verrs, ok := err.(validator.ValidationErrors)
+		if !ok {
+			return nil, fmt.Errorf("config: %w", err)
+		}
+		for _, fe := range verrs {
+			errs = append(errs, FieldError{Field: fieldPath(fe.Namespace()), Rule: fe.Tag(), Param: fe.Param()})
+		}
+	}
+	if len(errs) > 0 {
+		return nil, &ValidationError{Fields: errs}
+	}
+	return d.keys, nil
+}
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+	// report the mapstructure keys
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		return keyName(f)
+	})
+	// oneofci case insensitive oneof, for the values parsed case insensitively, e.g. the log levels
+	_ = v.RegisterValidation("oneofci", func(fl validator.FieldLevel) bool {
+		for _, allowed := range strings.Fields(fl.Param()) {
+			if strings.EqualFold(fl.Field().String(), allowed) {
+				return true
+			}
+		}
+		return false
+	})
+	return v
+}
+
+// fieldPath strip the root struct of a validator namespace
+func fieldPath(ns string) string {
+	if i := strings.Index(ns, "."); i >= 0 {
+		return ns[i+1:]
+	}
+	return ns
+}
+
+// keyName the config key of a struct field, as mapstructure decodes it
+func keyName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("mapstructure"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		name = strings.ToLower(f.Name)
+	}
+	return name
+}
+
+func joinKey(prefix, name string) string {
+	if prefix == "" || name == "" {
+		return prefix + name
+	}
+	return prefix + "." + name
+}
+
+// defaulter set the default tags
+type defaulter struct {
+	// keys set
+	keys []string
+	errs []FieldError
+}
+
+// setDefaults set the zero fields of the struct v to their default tag,
+// nested structs, non-nil pointers and slice elements are walked
+func (d *defaulter) setDefaults(v reflect.Value, prefix string) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		fv := v.Field(i)
+		key := prefix
+		if !f.Anonymous && !strings.Contains(f.Tag.Get("mapstructure"), "squash") {
+			key = joinKey(prefix, keyName(f))
+		}
+		if def, ok := f.Tag.Lookup("default"); ok && fv.IsZero() {
+			if err := setValue(fv, def); err != nil {
+				d.errs = append(d.errs, FieldError{Field: key, Rule: "default", Param: def})
+			} else {
+				d.keys = append(d.keys, key)
+			}
+			continue
+		}
+		d.walk(fv, key)
+	}
+}
+
+func (d *defaulter) walk(v reflect.Value, key string) {
+	switch v.Kind() {
+	case reflect.Struct:
+		d.setDefaults(v, key)
+	case reflect.Ptr:
+		if !v.IsNil() {
+			d.walk(v.Elem(), key)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			d.walk(v.Index(i), fmt.Sprintf("%s[%d]", key, i))
+		}
+	}
+}
+
+// setValue parse s into v
+func setValue(v reflect.Value, s string) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported default type %s", v.Type())
+		}
+		parts := strings.Split(s, ",")
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+		v.Set(reflect.ValueOf(parts).Convert(v.Type()))
+	default:
+		return fmt.Errorf("unsupported default type %s", v.Type())
+	}
+	return nil
+}
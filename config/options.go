@@ -0,0 +1,414 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultBase  = "config"
+	defaultLocal = "local"
+)
+
+// varRe ${VAR}, ${VAR:-default} or the escaped $${...}
+var varRe = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// exts extensions of the config files, tried in order
+var exts = []string{"yml", "yaml", "json", "toml"}
+
+// Options layered config, merged in order, later layers override the keys of the previous ones:
+//
+//  1. the base file Dir/config.yml, optional
+//  2. the environment overlay Dir/$ENV.yml, required if the environment is set
+//  3. the local override Dir/local.yml, optional, should not be committed
+//  4. the Overlays, e.g. a RedisSource
+//  5. the environment variables if EnvPrefix or EnvKeys is set, e.g. APP_DB_HOST overrides db.host,
+//     APP_DB_PASSWORD_FILE=/run/secrets/db sets db.password to the content of the file
+//  6. the command line Flags defined by BindFlags, e.g. --db.max-open-conns=50
+//
+// Files replaces the first three layers if set. Any of yml, yaml, json, toml is accepted.
+// The string values of the files may reference environment variables as ${VAR}
+// or ${VAR:-default}, an unset variable without default is an error, $${ escapes ${.
+type Options struct {
+	// Dir directory of the files, default .
+	Dir string
+	// Base name of the base file without extension, default config
+	Base string
+	// Env name of the environment overlay, default the lower case ENV environment variable, e.g. staging
+	Env string
+	// Local name of the local override file without extension, default local
+	Local string
+	// Files explicit files, merged in order, all required
+	Files []string
+	// Overlays sources merged in order after the files
+	Overlays []Source
+	// EnvPrefix prefix of the environment variables, e.g. APP maps APP_DB_HOST to db.host,
+	// required to read the variables named after the keys, an unprefixed HOST or USER would override them
+	EnvPrefix string
+	// EnvKeys extra environment variables by key, e.g. {"jwt.key": "JWT_SECRET"},
+	// they override the variables named after the key
+	EnvKeys map[string]string
+	// NoEnv skip the environment variables layer
+	NoEnv bool
+	// Flags parsed flag set defined by BindFlags, only the flags set on the command line apply
+	Flags *pflag.FlagSet
+	// Debounce delay of a reload after the last change of a file, default DefaultDebounce
+	Debounce time.Duration
+
+	mu sync.Mutex
+	// sources of the last Load
+	sources map[string]string
+}
+
+// Load merge the layers into out like Load
+func (o *Options) Load(out interface{}) error {
+	v, sources, err := o.viper(reflect.TypeOf(out))
+	if err != nil {
+		return err
+	}
+	defaults, err := decode(v, out)
+	if err != nil {
+		return err
+	}
+	o.setSources(withDefaults(sources, defaults))
+	return nil
+}
+
+// Watch load the layers into out and reload them when one of the files changes, see NewWatcher
+func (o *Options) Watch(out interface{}) (*Watcher, error) {
+	return newWatcher(o, out, &subscribers{})
+}
+
+// layers return the file sources followed by the overlays
+func (o *Options) layers() ([]Source, error) {
+	files, err := o.files()
+	if err != nil {
+		return nil, err
+	}
+	sources := make([]Source, 0, len(files)+len(o.Overlays))
+	for _, f := range files {
+		sources = append(sources, f)
+	}
+	return append(sources, o.Overlays...), nil
+}
+
+// files return the files to merge in order
+func (o *Options) files() ([]*FileSource, error) {
+	if len(o.Files) > 0 {
+		files := make([]*FileSource, 0, len(o.Files))
+		for _, f := range o.Files {
+			files = append(files, &FileSource{Path: f})
+		}
+		return files, nil
+	}
+	dir := o.Dir
+	if dir == "" {
+		dir = "."
+	}
+	base := o.Base
+	if base == "" {
+		base = defaultBase
+	}
+	local := o.Local
+	if local == "" {
+		local = defaultLocal
+	}
+	env := o.Env
+	if env == "" {
+		env = strings.ToLower(os.Getenv("ENV"))
+	}
+	files := []*FileSource{{Path: findFile(dir, base), Optional: true}}
+	if env != "" {
+		files = append(files, &FileSource{Path: findFile(dir, env)})
+	}
+	files = append(files, &FileSource{Path: findFile(dir, local), Optional: true})
+	found := false
+	for _, f := range files {
+		if _, err := os.Stat(f.Path); err == nil {
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("config: no config file in %s", dir)
+	}
+	return files, nil
+}
+
+// viper merge the layers and return the source of each key,
+// typ is the type of the output used to find the keys of the environment variables
+func (o *Options) viper(typ reflect.Type) (*viper.Viper, map[string]string, error) {
+	srcs, err := o.layers()
+	if err != nil {
+		return nil, nil, err
+	}
+	settings := make(map[string]interface{})
+	sources := make(map[string]string)
+	for _, src := range srcs {
+		layer, err := src.Load()
+		if err != nil {
+			return nil, nil, err
+		}
+		merge(settings, layer)
+		for _, key := range keys(layer, nil) {
+			sources[key] = src.Name()
+		}
+	}
+	if err := interpolate(settings, ""); err != nil {
+		return nil, nil, err
+	}
+	if !o.NoEnv {
+		if err := o.applyEnv(settings, typ, sources); err != nil {
+			return nil, nil, err
+		}
+	}
+	if o.Flags != nil {
+		if err := o.applyFlags(settings, sources); err != nil {
+			return nil, nil, err
+		}
+	}
+	v := viper.New()
+	if err := v.MergeConfigMap(settings); err != nil {
+		return nil, nil, err
+	}
+	return v, sources, nil
+}
+
+// withDefaults add the keys set by default tags to sources
+func withDefaults(sources map[string]string, defaults []string) map[string]string {
+	for _, key := range defaults {
+		sources[key] = SourceDefault
+	}
+	return sources
+}
+
+// applyEnv set the keys of settings and typ overridden by environment variables
+func (o *Options) applyEnv(settings map[string]interface{}, typ reflect.Type, sources map[string]string) error {
+	list := keys(settings, typ)
+	for key := range o.EnvKeys {
+		list = append(list, strings.ToLower(key))
+	}
+	for _, key := range list {
+		var names []string
+		if o.EnvPrefix != "" {
+			names = append(names, envName(o.EnvPrefix, key))
+		}
+		if name, ok := o.envKey(key); ok {
+			names = append(names, name)
+		}
+		for _, name := range names {
+			value, from, err := lookupEnv(name)
+			if err != nil {
+				return err
+			}
+			if from != "" {
+				set(settings, key, value)
+				sources[key] = SourceEnv + ":" + from
+			}
+		}
+	}
+	return nil
+}
+
+func (o *Options) envKey(key string) (string, bool) {
+	for k, name := range o.EnvKeys {
+		if strings.EqualFold(k, key) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// lookupEnv return the value of the variable name, or the content of the file named by name_FILE,
+// from is the variable read, empty if none is set
+func lookupEnv(name string) (value, from string, err error) {
+	if value, ok := os.LookupEnv(name); ok {
+		return value, name, nil
+	}
+	file, ok := os.LookupEnv(name + "_FILE")
+	if !ok {
+		return "", "", nil
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", "", fmt.Errorf("config: %s_FILE: %w", name, err)
+	}
+	return strings.TrimRight(string(b), "\r\n"), name + "_FILE", nil
+}
+
+// interpolate replace the ${VAR} references of the string values of settings
+func interpolate(settings map[string]interface{}, prefix string) error {
+	for k, v := range settings {
+		value, err := interpolateValue(v, joinKey(prefix, k))
+		if err != nil {
+			return err
+		}
+		settings[k] = value
+	}
+	return nil
+}
+
+func interpolateValue(v interface{}, key string) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		return expand(v, key)
+	case map[string]interface{}:
+		return v, interpolate(v, key)
+	case []interface{}:
+		for i := range v {
+			value, err := interpolateValue(v[i], fmt.Sprintf("%s[%d]", key, i))
+			if err != nil {
+				return nil, err
+			}
+			v[i] = value
+		}
+	}
+	return v, nil
+}
+
+// expand replace the ${VAR} and ${VAR:-default} of s
+func expand(s, key string) (string, error) {
+	var err error
+	s = varRe.ReplaceAllStringFunc(s, func(ref string) string {
+		if strings.HasPrefix(ref, "$$") {
+			return ref[1:]
+		}
+		m := varRe.FindStringSubmatch(ref)
+		if value, ok := os.LookupEnv(m[1]); ok {
+			return value
+		}
+		if m[2] != "" {
+			return m[3]
+		}
+		if err == nil {
+			err = fmt.Errorf("config: %s: ${%s} is not set", key, m[1])
+		}
+		return ref
+	})
+	return s, err
+}
+
+// findFile return the first existing file of name in dir, dir/name.yml if none
+func findFile(dir, name string) string {
+	for _, ext := range exts {
+		path := filepath.Join(dir, name+"."+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return filepath.Join(dir, name+"."+exts[0])
+}
+
+// envName the environment variable of key, e.g. APP_DB_MAX_OPEN_CONNS for db.max_open_conns
+func envName(prefix, key string) string {
+	name := strings.NewReplacer(".", "_", "-", "_").Replace(key)
+	if prefix != "" {
+		name = prefix + "_" + name
+	}
+	return strings.ToUpper(name)
+}
+
+// merge deep merge src into dst
+func merge(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if sm, ok := v.(map[string]interface{}); ok {
+			if dm, ok := dst[k].(map[string]interface{}); ok {
+				merge(dm, sm)
+				continue
+			}
+			cp := make(map[string]interface{}, len(sm))
+			merge(cp, sm)
+			dst[k] = cp
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// set the value of a dotted key, creating the intermediate maps
+func set(settings map[string]interface{}, key string, value interface{}) {
+	parts := strings.Split(key, ".")
+	m := settings
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := m[part].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			m[part] = next
+		}
+		m = next
+	}
+	m[parts[len(parts)-1]] = value
+}
+
+// keys return the sorted leaf keys of settings and of the struct type typ
+func keys(settings map[string]interface{}, typ reflect.Type) []string {
+	seen := make(map[string]bool)
+	settingKeys("", settings, seen)
+	if typ != nil {
+		structKeys(typ, "", seen)
+	}
+	list := make([]string, 0, len(seen))
+	for k := range seen {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func settingKeys(prefix string, settings map[string]interface{}, seen map[string]bool) {
+	for k, v := range settings {
+		key := joinKey(prefix, k)
+		if m, ok := v.(map[string]interface{}); ok {
+			settingKeys(key, m, seen)
+			continue
+		}
+		seen[key] = true
+	}
+}
+
+func structKeys(t reflect.Type, prefix string, seen map[string]bool) {
+	walkFields(t, prefix, func(key string, _ reflect.StructField) {
+		seen[key] = true
+	})
+}
+
+// walkFields call fn with the key of each leaf field of the struct type t,
+// nested structs and pointers to structs are walked
+func walkFields(t reflect.Type, prefix string, fn func(key string, f reflect.StructField)) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Tag.Get("mapstructure") == "-" {
+			continue
+		}
+		squash := f.Anonymous || strings.Contains(f.Tag.Get("mapstructure"), "squash")
+		key := prefix
+		if !squash {
+			key = joinKey(prefix, keyName(f))
+		}
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct && ft != reflect.TypeOf(time.Time{}) {
+			walkFields(ft, key, fn)
+			continue
+		}
+		fn(key, f)
+	}
+}
@@ -0,0 +1,165 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/happyxhw/gopkg/goredis"
+	"github.com/happyxhw/gopkg/logger"
+	"go.uber.org/zap"
+)
+
+// SourceRedis prefix of the redis sources in Dump, followed by the key, e.g. redis:app:config
+const SourceRedis = "redis"
+
+const defaultPollInterval = 10 * time.Second
+
+// RedisSource settings stored in a redis key, either a hash of dotted keys to values,
+// e.g. HSET app:config log.level debug, or a string holding a json object.
+// Changes are seen through keyspace notifications if the server enables them
+// for the key type (notify-keyspace-events with K and h or $, or A), else by polling.
+type RedisSource struct {
+	client *redis.Client
+	key    string
+	poll   time.Duration
+}
+
+// NewRedisSource connect to redis with goredis.NewRedis, poll 0 uses 10s
+func NewRedisSource(c *goredis.Config, key string, poll time.Duration) (*RedisSource, error) {
+	client, err := goredis.NewRedis(c)
+	if err != nil {
+		return nil, err
+	}
+	return NewRedisClientSource(client, key, poll), nil
+}
+
+// NewRedisClientSource return a source reading key with client
+func NewRedisClientSource(client *redis.Client, key string, poll time.Duration) *RedisSource {
+	if poll <= 0 {
+		poll = defaultPollInterval
+	}
+	return &RedisSource{client: client, key: key, poll: poll}
+}
+
+func (s *RedisSource) Name() string {
+	return SourceRedis + ":" + s.key
+}
+
+// Load return no settings if the key does not exist
+func (s *RedisSource) Load() (map[string]interface{}, error) {
+	typ, err := s.client.Type(s.key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("config: redis %s: %w", s.key, err)
+	}
+	settings := make(map[string]interface{})
+	switch typ {
+	case "none":
+	case "hash":
+		fields, err := s.client.HGetAll(s.key).Result()
+		if err != nil {
+			return nil, fmt.Errorf("config: redis %s: %w", s.key, err)
+		}
+		for k, v := range fields {
+			set(settings, strings.ToLower(k), v)
+		}
+	case "string":
+		raw, err := s.client.Get(s.key).Bytes()
+		if err != nil {
+			return nil, fmt.Errorf("config: redis %s: %w", s.key, err)
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return nil, fmt.Errorf("config: redis %s: %w", s.key, err)
+		}
+		merge(settings, lowerKeys(m))
+	default:
+		return nil, fmt.Errorf("config: redis %s: unsupported type %s", s.key, typ)
+	}
+	return settings, nil
+}
+
+func (s *RedisSource) Watch(stop <-chan struct{}, onChange func()) error {
+	if s.notifications() {
+		return s.subscribe(stop, onChange)
+	}
+	last, err := s.Load()
+	if err != nil {
+		return err
+	}
+	go s.pollChanges(last, stop, onChange)
+	return nil
+}
+
+// notifications report whether the server publishes the keyspace events of hashes and strings
+func (s *RedisSource) notifications() bool {
+	res, err := s.client.ConfigGet("notify-keyspace-events").Result()
+	if err != nil || len(res) < 2 {
+		return false
+	}
+	flags, _ := res[1].(string)
+	return strings.Contains(flags, "K") &&
+		(strings.Contains(flags, "A") || (strings.Contains(flags, "h") && strings.Contains(flags, "$")))
+}
+
+func (s *RedisSource) subscribe(stop <-chan struct{}, onChange func()) error {
+	channel := fmt.Sprintf("__keyspace@%d__:%s", s.client.Options().DB, s.key)
+	ps := s.client.Subscribe(channel)
+	if _, err := ps.Receive(); err != nil {
+		_ = ps.Close()
+		return fmt.Errorf("config: redis subscribe %s: %w", channel, err)
+	}
+	go func() {
+		defer ps.Close()
+		ch := ps.Channel()
+		for {
+			select {
+			case _, ok := <-ch:
+				if !ok {
+					return
+				}
+				onChange()
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// pollChanges call onChange when the settings differ from last
+func (s *RedisSource) pollChanges(last map[string]interface{}, stop <-chan struct{}, onChange func()) {
+	ticker := time.NewTicker(s.poll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			settings, err := s.Load()
+			if err != nil {
+				logger.Error("config poll", zap.String("source", s.Name()), zap.Error(err))
+				continue
+			}
+			if !reflect.DeepEqual(settings, last) {
+				last = settings
+				onChange()
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// lowerKeys lower case the keys of nested maps like viper does for files
+func lowerKeys(m map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if sub, ok := v.(map[string]interface{}); ok {
+			v = lowerKeys(sub)
+		}
+		out[strings.ToLower(k)] = v
+	}
+	return out
+}
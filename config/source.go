@@ -0,0 +1,81 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/happyxhw/gopkg/logger"
+	"go.uber.org/zap"
+)
+
+// Source a layer of the config
+type Source interface {
+	// Name describe the source in Dump, e.g. file:config/dev.yml
+	Name() string
+	// Load return the settings, nested maps keyed like the config files
+	Load() (map[string]interface{}, error)
+	// Watch call onChange in the background when the settings may have changed, until stop is closed
+	Watch(stop <-chan struct{}, onChange func()) error
+}
+
+// FileSource a config file, yml, yaml, json or toml
+type FileSource struct {
+	Path string
+	// Optional a missing file has no settings
+	Optional bool
+}
+
+func (s *FileSource) Name() string {
+	return SourceFile + ":" + s.Path
+}
+
+func (s *FileSource) Load() (map[string]interface{}, error) {
+	if _, err := os.Stat(s.Path); err != nil && s.Optional {
+		return map[string]interface{}{}, nil
+	}
+	v, err := read(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	return v.AllSettings(), nil
+}
+
+// Watch the directory of the file to see atomic saves and k8s ConfigMap symlink swaps
+func (s *FileSource) Watch(stop <-chan struct{}, onChange func()) error {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	file := filepath.Clean(s.Path)
+	if err := fw.Add(filepath.Dir(file)); err != nil {
+		_ = fw.Close()
+		return err
+	}
+	go func() {
+		defer fw.Close()
+		realFile, _ := filepath.EvalSymlinks(file)
+		for {
+			select {
+			case event, ok := <-fw.Events:
+				if !ok {
+					return
+				}
+				current, _ := filepath.EvalSymlinks(file)
+				changed := filepath.Clean(event.Name) == file &&
+					event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0
+				if changed || current != realFile {
+					realFile = current
+					onChange()
+				}
+			case err, ok := <-fw.Errors:
+				if ok {
+					logger.Error("config watch", zap.String("file", s.Path), zap.Error(err))
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
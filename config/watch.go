@@ -0,0 +1,347 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/happyxhw/gopkg/logger"
+	"go.uber.org/zap"
+)
+
+// DefaultDebounce delay of a reload after the last change of the file
+const DefaultDebounce = 500 * time.Millisecond
+
+var (
+	defaultWatcherMu sync.Mutex
+	defaultWatcher   *Watcher
+	// defaultSubs subscribers of the default watcher, registered before or after Watch
+	defaultSubs = &subscribers{}
+)
+
+var loggerConfigType = reflect.TypeOf(logger.Config{})
+
+type subscriber struct {
+	key string
+	fn  func(old, new interface{})
+}
+
+type subscribers struct {
+	mu   sync.RWMutex
+	list []subscriber
+	// wired keys of the built-in subscribers, registered once
+	wired map[string]bool
+}
+
+func (s *subscribers) add(key string, fn func(old, new interface{})) {
+	s.mu.Lock()
+	s.list = append(s.list, subscriber{key: strings.ToLower(key), fn: fn})
+	s.mu.Unlock()
+}
+
+// wire add a built-in subscriber unless key already has one
+func (s *subscribers) wire(key string, fn func(old, new interface{})) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.wired == nil {
+		s.wired = make(map[string]bool)
+	}
+	if !s.wired[key] {
+		s.wired[key] = true
+		s.list = append(s.list, subscriber{key: key, fn: fn})
+	}
+}
+
+func (s *subscribers) notify(old, new map[string]interface{}) {
+	s.mu.RLock()
+	list := append([]subscriber(nil), s.list...)
+	s.mu.RUnlock()
+	for _, sub := range list {
+		o, n := lookup(old, sub.key), lookup(new, sub.key)
+		if !reflect.DeepEqual(o, n) {
+			sub.fn(o, n)
+		}
+	}
+}
+
+// Watcher reload a config when one of its sources changes
+type Watcher struct {
+	o        *Options
+	srcs     []Source
+	typ      reflect.Type
+	debounce time.Duration
+	subs     *subscribers
+
+	closeOnce sync.Once
+	mu        sync.Mutex
+	settings  map[string]interface{}
+	current   atomic.Value
+
+	changeCh chan struct{}
+	stopCh   chan struct{}
+	doneCh   chan struct{}
+}
+
+// NewWatcher load path into out like Load, then reload it debounce after the file changes,
+// 0 uses DefaultDebounce.
+// A reload decodes and validates the file into a new value of the type of out,
+// an invalid file is logged and the previous config is kept.
+// out is not updated, Current returns the last valid config.
+func NewWatcher(path string, out interface{}, debounce time.Duration) (*Watcher, error) {
+	return newWatcher(&Options{Files: []string{path}, NoEnv: true, Debounce: debounce}, out, &subscribers{})
+}
+
+func newWatcher(o *Options, out interface{}, subs *subscribers) (*Watcher, error) {
+	debounce := o.Debounce
+	if debounce <= 0 {
+		debounce = DefaultDebounce
+	}
+	srcs, err := o.layers()
+	if err != nil {
+		return nil, err
+	}
+	typ := reflect.TypeOf(out)
+	v, sources, err := o.viper(typ)
+	if err != nil {
+		return nil, err
+	}
+	defaults, err := decode(v, out)
+	if err != nil {
+		return nil, err
+	}
+	o.setSources(withDefaults(sources, defaults))
+	w := &Watcher{
+		o:        o,
+		srcs:     srcs,
+		typ:      typ.Elem(),
+		debounce: debounce,
+		subs:     subs,
+		settings: v.AllSettings(),
+		changeCh: make(chan struct{}, 1),
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+	for _, src := range srcs {
+		if err := src.Watch(w.stopCh, w.changed); err != nil {
+			close(w.stopCh)
+			return nil, err
+		}
+	}
+	w.current.Store(out)
+	go w.run()
+	return w, nil
+}
+
+// OnChange call fn with the old and the new value of key after a reload changed it,
+// key is a dotted path, e.g. log.level, empty for the whole config
+func (w *Watcher) OnChange(key string, fn func(old, new interface{})) {
+	w.subs.add(key, fn)
+}
+
+// Current return the last valid config, a pointer of the type passed to NewWatcher
+func (w *Watcher) Current() interface{} {
+	return w.current.Load()
+}
+
+// Reload read the files now, keep the previous config if it is invalid
+func (w *Watcher) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	v, sources, err := w.o.viper(reflect.PtrTo(w.typ))
+	if err != nil {
+		return err
+	}
+	out := reflect.New(w.typ).Interface()
+	defaults, err := decode(v, out)
+	if err != nil {
+		return err
+	}
+	w.o.setSources(withDefaults(sources, defaults))
+	old, settings := w.settings, v.AllSettings()
+	w.settings = settings
+	prev := w.current.Load()
+	w.current.Store(out)
+	if changes := diff(prev, out); len(changes) > 0 {
+		logger.Info("config changed", zap.Strings("sources", w.names()), zap.Strings("changes", changes))
+	}
+	w.subs.notify(old, settings)
+	return nil
+}
+
+// Dump render the current config like Options.Dump
+func (w *Watcher) Dump(format string) ([]byte, error) {
+	return dump(w.Current(), format, w.o.Sources())
+}
+
+// Close stop watching
+func (w *Watcher) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.stopCh)
+		<-w.doneCh
+	})
+	return nil
+}
+
+func (w *Watcher) run() {
+	defer close(w.doneCh)
+	var timer *time.Timer
+	var fire <-chan time.Time
+	for {
+		select {
+		case <-w.changeCh:
+			if timer == nil {
+				timer = time.NewTimer(w.debounce)
+			} else {
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(w.debounce)
+			}
+			fire = timer.C
+		case <-fire:
+			fire = nil
+			if err := w.Reload(); err != nil {
+				logger.Error("config reload, keep the previous config", zap.Strings("sources", w.names()), zap.Error(err))
+			}
+		case <-w.stopCh:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		}
+	}
+}
+
+// changed schedule a reload, called by the sources
+func (w *Watcher) changed() {
+	select {
+	case w.changeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (w *Watcher) names() []string {
+	names := make([]string, 0, len(w.srcs))
+	for _, src := range w.srcs {
+		names = append(names, src.Name())
+	}
+	return names
+}
+
+// Watch load path into out with NewWatcher and make it the default watcher of OnChange and Current,
+// the previous default watcher is closed
+func Watch(path string, out interface{}) error {
+	return WatchOptions(&Options{Files: []string{path}, NoEnv: true}, out)
+}
+
+// WatchOptions load the layers of o into out and make it the default watcher of OnChange and Current,
+// the previous default watcher is closed.
+// The level and the named levels of a logger.Config field are applied to the default logger on change.
+func WatchOptions(o *Options, out interface{}) error {
+	w, err := newWatcher(o, out, defaultSubs)
+	if err != nil {
+		return err
+	}
+	wireLogger(w.typ, "", defaultSubs.wire)
+	defaultWatcherMu.Lock()
+	prev := defaultWatcher
+	defaultWatcher = w
+	defaultWatcherMu.Unlock()
+	if prev != nil {
+		_ = prev.Close()
+	}
+	return nil
+}
+
+// OnChange call fn with the old and the new value of key after the default watcher reloaded it
+func OnChange(key string, fn func(old, new interface{})) {
+	defaultSubs.add(key, fn)
+}
+
+// Current return the last valid config of the default watcher, nil before Watch
+func Current() interface{} {
+	defaultWatcherMu.Lock()
+	w := defaultWatcher
+	defaultWatcherMu.Unlock()
+	if w == nil {
+		return nil
+	}
+	return w.Current()
+}
+
+// lookup return the value of a dotted key in nested settings
+func lookup(settings map[string]interface{}, key string) interface{} {
+	if key == "" {
+		return settings
+	}
+	var v interface{} = settings
+	for _, part := range strings.Split(key, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		if v, ok = m[part]; !ok {
+			return nil
+		}
+	}
+	return v
+}
+
+// wireLogger subscribe the default logger to the level and the named levels of the logger.Config fields of t
+func wireLogger(t reflect.Type, prefix string, onChange func(string, func(old, new interface{}))) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		key := prefix
+		if !f.Anonymous && !strings.Contains(f.Tag.Get("mapstructure"), "squash") {
+			key = joinKey(prefix, keyName(f))
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		switch {
+		case ft == loggerConfigType:
+			onChange(joinKey(key, "level"), func(_, new interface{}) {
+				text, _ := new.(string)
+				l, err := logger.ParseLevel(text)
+				if err != nil {
+					logger.Error("config reload log level", zap.Error(err))
+					return
+				}
+				logger.SetLevel(l)
+			})
+			onChange(joinKey(key, "levels"), func(old, new interface{}) {
+				updateNamedLevels(old, new)
+			})
+		case ft.Kind() == reflect.Struct:
+			wireLogger(ft, key, onChange)
+		}
+	}
+}
+
+func updateNamedLevels(old, new interface{}) {
+	oldLevels, _ := old.(map[string]interface{})
+	newLevels, _ := new.(map[string]interface{})
+	for name := range oldLevels {
+		if _, ok := newLevels[name]; !ok {
+			logger.ResetNamedLevel(name)
+		}
+	}
+	for name, text := range newLevels {
+		l, err := logger.ParseLevel(fmt.Sprint(text))
+		if err != nil {
+			logger.Error("config reload log level", zap.String("name", name), zap.Error(err))
+			continue
+		}
+		logger.SetNamedLevel(name, l)
+	}
+}
@@ -1,15 +1,19 @@
 package dbgo
 
 import (
+	"database/sql"
 	"errors"
 	"fmt"
+	"net/url"
 	"time"
 
+	"github.com/happyxhw/gopkg/logger"
 	"go.uber.org/zap"
 
 	// mysql
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
@@ -21,19 +25,90 @@ const (
 	MysqlDB DBType = iota
 	// PgDB postgresql
 	PgDB
+	// SqliteDB sqlite, DB is the file, User, Password, Host and Port are ignored
+	SqliteDB
 )
 
+// sqliteMemoryDB name of the in-memory sqlite database if DB is empty
+const sqliteMemoryDB = "dbgo"
+
 type Config struct {
-	User         string
-	Password     string
-	Host         string
-	Port         int
-	DB           string
-	MaxIdleConns int `mapstructure:"max_idle_conns"`
-	MaxOpenConns int `mapstructure:"max_open_conns"`
-	MaxLifeTime  int `mapstructure:"max_life_time"`
-	Logger       *zap.Logger
-	Level        string
+	// User and DB required for mysql and postgres, DB for a sqlite file
+	User     string `desc:"database user"`
+	Password string `desc:"database password"`
+	Host     string `default:"127.0.0.1" desc:"database host"`
+	// Port default 3306 for mysql, 5432 for postgres
+	Port int    `validate:"min=0,max=65535" desc:"database port, 0 uses the driver default"`
+	DB   string `desc:"database name"`
+	// SSLMode disable, allow, prefer, require, verify-ca or verify-full, default disable,
+	// require encrypts without verifying the server, unless SSLRootCert is set for postgres,
+	// allow and prefer fall back to plaintext, for mysql they do not accept SSLCert
+	SSLMode string `mapstructure:"ssl_mode" validate:"omitempty,oneof=disable allow prefer require verify-ca verify-full" desc:"tls mode"`
+	// SSLRootCert CA file verifying the server, SSLCert and SSLKey client certificate files
+	SSLRootCert string `mapstructure:"ssl_root_cert" desc:"tls CA file"`
+	SSLCert     string `mapstructure:"ssl_cert" desc:"tls client certificate file"`
+	SSLKey      string `mapstructure:"ssl_key" desc:"tls client key file"`
+	// Timezone IANA name, the loc of the mysql times, the TimeZone of the postgres session,
+	// default UTC for mysql, the server one for postgres
+	Timezone string `desc:"time zone of the connection"`
+	// Charset mysql only, default utf8mb4
+	Charset string `desc:"mysql charset"`
+	// Schema postgres only, the search_path of the session
+	Schema string `desc:"postgres search_path"`
+	// ApplicationName postgres only, shown in pg_stat_activity
+	ApplicationName string `mapstructure:"application_name" desc:"postgres application_name"`
+	// DialTimeout timeout of a new connection, rounded up to the second for postgres
+	DialTimeout time.Duration `mapstructure:"dial_timeout" desc:"timeout of a new connection"`
+	// ReadTimeout and WriteTimeout mysql only, i/o timeouts of the connections
+	ReadTimeout  time.Duration `mapstructure:"read_timeout" desc:"mysql i/o read timeout"`
+	WriteTimeout time.Duration `mapstructure:"write_timeout" desc:"mysql i/o write timeout"`
+	// Params extra dsn parameters, overriding the ones above,
+	// e.g. mysql system variables or postgres runtime parameters
+	Params       map[string]string
+	MaxIdleConns int `mapstructure:"max_idle_conns" validate:"min=0" desc:"max idle connections"`
+	MaxOpenConns int `mapstructure:"max_open_conns" validate:"min=0" desc:"max open connections"`
+	MaxLifeTime  int `mapstructure:"max_life_time" validate:"min=0" desc:"max connection lifetime in seconds"`
+	// Logger default logger.Named("dbgo") whose caller is the first frame out of gorm and dbgo,
+	// the caller of a Logger is the gorm logger method plus its caller skip
+	Logger *zap.Logger `mapstructure:"-"`
+	// Level silent, error, warn, info, default warn
+	Level string `validate:"omitempty,oneof=silent error warn info" desc:"gorm log level"`
+	// SlowThreshold queries slower are logged as warnings, default 1s, negative disables
+	SlowThreshold time.Duration `mapstructure:"slow_threshold" default:"1s" desc:"slow query threshold"`
+	// Parameterized log the sql with its placeholders instead of the inlined values
+	Parameterized bool `desc:"log the sql without its values"`
+	// IgnoreRecordNotFound do not log gorm.ErrRecordNotFound as an error
+	IgnoreRecordNotFound bool `mapstructure:"ignore_record_not_found" desc:"do not log record not found errors"`
+	// Memory sqlite only, open the in-memory database named DB, shared by the connections of the pool,
+	// it is dropped when the last connection closes
+	Memory bool `desc:"sqlite in-memory database"`
+	// Replicas read only replicas of the primary described above,
+	// queries go to a healthy replica, writes, transactions and WithPrimary contexts to the primary
+	Replicas []ReplicaConfig `validate:"dive"`
+	// Policy replica selection, random, round_robin or least_latency, default random
+	Policy string `validate:"omitempty,oneof=random round_robin least_latency" desc:"replica selection policy"`
+	// HealthCheckInterval interval of the replica pings, an unreachable replica is ejected until it answers again
+	HealthCheckInterval time.Duration `mapstructure:"health_check_interval" default:"10s" desc:"replica health check interval"`
+	// ConnectRetries retries of the first connection, e.g. while the database starts
+	ConnectRetries int `mapstructure:"connect_retries" validate:"min=0" desc:"retries of the first connection"`
+	// ConnectBackoff max backoff between the connection retries, default 5s
+	ConnectBackoff time.Duration `mapstructure:"connect_backoff" desc:"max backoff between the connection retries"`
+	// ConnectTimeout max duration of the retries of the first connection, default no limit,
+	// the last attempt may exceed it by DialTimeout
+	ConnectTimeout time.Duration `mapstructure:"connect_timeout" desc:"max duration of the first connection with its retries"`
+	// MonitorInterval interval of the background HealthCheck logging the degraded state, 0 disables it
+	MonitorInterval time.Duration `mapstructure:"monitor_interval" desc:"interval of the background health check"`
+	// SaturationThreshold share of the max open connections in use from which the pool is degraded, default 0.9
+	SaturationThreshold float64 `mapstructure:"saturation_threshold" validate:"min=0,max=1" desc:"pool saturation reported as degraded"`
+}
+
+// ReplicaConfig a read only replica, the empty fields are the ones of the primary
+type ReplicaConfig struct {
+	Host     string
+	Port     int `validate:"min=0,max=65535"`
+	User     string
+	Password string
+	DB       string
 }
 
 func NewMysqlDB(dbConfig *Config) (*gorm.DB, error) {
@@ -46,48 +121,145 @@ func NewPostgresDB(dbConfig *Config) (*gorm.DB, error) {
 	return DB, err
 }
 
-func createConnection(dbConfig *Config, dbType DBType) (*gorm.DB, error) {
-	var db *gorm.DB
-	var err error
-	host := dbConfig.Host
-	user := dbConfig.User
-	dbName := dbConfig.DB
-	password := dbConfig.Password
-	port := dbConfig.Port
-	if port == 0 {
-		port = 3306
+// NewSqliteDB open the sqlite file DB, or the in-memory database DB if Memory is set, e.g. for tests:
+//
+//	db, err := dbgo.NewSqliteDB(&dbgo.Config{DB: t.Name(), Memory: true})
+func NewSqliteDB(dbConfig *Config) (*gorm.DB, error) {
+	DB, err := createConnection(dbConfig, SqliteDB)
+	return DB, err
+}
+
+// sqliteDSN the sqlite dsn of c, a shared cache memory uri if Memory is set
+func sqliteDSN(c *Config) string {
+	if !c.Memory {
+		return c.DB
 	}
-	if host == "" {
-		host = "127.0.0.1"
+	name := c.DB
+	if name == "" {
+		name = sqliteMemoryDB
 	}
+	return fmt.Sprintf("file:%s?mode=memory&cache=shared", url.PathEscape(name))
+}
 
+func createConnection(dbConfig *Config, dbType DBType) (*gorm.DB, error) {
+	if err := checkConfig(dbConfig, dbType); err != nil {
+		return nil, err
+	}
+	d, err := dialector(dbConfig, dbType)
+	if err != nil {
+		return nil, err
+	}
 	c := gorm.Config{}
-	if dbConfig.Logger != nil {
-		c.Logger = newLogger(dbConfig.Logger, dbConfig.Level)
-	}
-	if dbType == MysqlDB {
-		url := fmt.Sprintf("%s:%s@(%s:%d)/%s?charset=UTF8&parseTime=true", user, password, host, port, dbName)
-		db, err = gorm.Open(mysql.Open(url), &c)
-	} else if dbType == PgDB {
-		url := fmt.Sprintf(
-			"host=%s port=%d user=%s dbname=%s password=%s sslmode=disable",
-			host, port, user, dbName, password,
-		)
-		db, err = gorm.Open(postgres.Open(url), &c)
-	} else {
-		return nil, errors.New("unknown db type")
-	}
-
-	if err == nil {
-		if dbConfig.MaxIdleConns != 0 && dbConfig.MaxOpenConns != 0 {
-			sqlDB, err2 := db.DB()
-			if err2 != nil {
-				return nil, err2
-			}
-			sqlDB.SetMaxIdleConns(dbConfig.MaxIdleConns)
-			sqlDB.SetMaxOpenConns(dbConfig.MaxOpenConns)
-			sqlDB.SetConnMaxLifetime(time.Duration(dbConfig.MaxLifeTime) * time.Second)
+	c.Logger = newLogger(dbLogger(dbConfig), dbConfig)
+	db, err := connect(d, &c, dbConfig)
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	setPool(sqlDB, dbConfig)
+	if err := usePlugins(db, sqlDB, dbConfig, dbType); err != nil {
+		_ = Close(db)
+		return nil, err
+	}
+	return db, nil
+}
+
+// usePlugins register the plugins of dbConfig, a plugin failing to initialize is closed
+func usePlugins(db *gorm.DB, sqlDB *sql.DB, dbConfig *Config, dbType DBType) error {
+	if dbConfig.Parameterized {
+		if err := db.Use(rawSQL{}); err != nil {
+			return err
 		}
 	}
-	return db, err
+	m := newMetrics(dbConfig, dbType, sqlDB)
+	if err := db.Use(m); err != nil {
+		m.close()
+		return err
+	}
+	if len(dbConfig.Replicas) > 0 {
+		r, err := newResolver(dbConfig, dbType, db.Logger)
+		if err != nil {
+			return err
+		}
+		if err := db.Use(r); err != nil {
+			r.close()
+			return err
+		}
+	}
+	if dbConfig.MonitorInterval > 0 {
+		mon := newMonitor(dbConfig)
+		if err := db.Use(mon); err != nil {
+			mon.close()
+			return err
+		}
+	}
+	return nil
+}
+
+// Close stop the monitor and the health checks, unregister the pool metrics
+// and close the connections of db and its replicas
+func Close(db *gorm.DB) error {
+	if m, ok := db.Config.Plugins[monitorName].(*monitor); ok {
+		m.close()
+	}
+	if r, ok := db.Config.Plugins[resolverName].(*resolver); ok {
+		r.close()
+	}
+	if m, ok := db.Config.Plugins[metricsName].(*metrics); ok {
+		m.close()
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// checkConfig check the fields required by dbType, the struct tags being the same for all the drivers
+func checkConfig(dbConfig *Config, dbType DBType) error {
+	switch {
+	case dbType == SqliteDB && dbConfig.DB == "" && !dbConfig.Memory:
+		return errors.New("sqlite: db file required")
+	case dbType != SqliteDB && dbConfig.User == "":
+		return errors.New("user required")
+	case dbType != SqliteDB && dbConfig.DB == "":
+		return errors.New("db required")
+	}
+	return nil
+}
+
+// dialector return the gorm dialector of dbConfig
+func dialector(dbConfig *Config, dbType DBType) (gorm.Dialector, error) {
+	switch dbType {
+	case MysqlDB:
+		dsn, err := mysqlDSN(dbConfig)
+		if err != nil {
+			return nil, err
+		}
+		return mysql.Open(dsn), nil
+	case PgDB:
+		return postgres.Open(postgresDSN(dbConfig)), nil
+	case SqliteDB:
+		return sqlite.Open(sqliteDSN(dbConfig)), nil
+	}
+	return nil, errors.New("unknown db type")
+}
+
+func dbLogger(dbConfig *Config) *zap.Logger {
+	if dbConfig.Logger != nil {
+		return dbConfig.Logger
+	}
+	return logger.Named("dbgo")
+}
+
+// setPool apply the pool settings of dbConfig to sqlDB
+func setPool(sqlDB *sql.DB, dbConfig *Config) {
+	if dbConfig.MaxIdleConns != 0 && dbConfig.MaxOpenConns != 0 {
+		sqlDB.SetMaxIdleConns(dbConfig.MaxIdleConns)
+		sqlDB.SetMaxOpenConns(dbConfig.MaxOpenConns)
+		sqlDB.SetConnMaxLifetime(time.Duration(dbConfig.MaxLifeTime) * time.Second)
+	}
 }
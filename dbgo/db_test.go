@@ -1,30 +1,533 @@
 package dbgo
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
-	"uself/pkg/logger"
-
+	"github.com/go-sql-driver/mysql"
+	"github.com/happyxhw/gopkg/config"
+	"github.com/happyxhw/gopkg/logger"
+	"github.com/happyxhw/gopkg/logger/loggertest"
+	"github.com/jackc/pgconn"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gLogger "gorm.io/gorm/logger"
 )
 
-func TestMysql(t *testing.T) {
-	l := logger.SetUp(zapcore.InfoLevel, "", logger.ConsoleEncoder)
-	db, err := NewPostgresDB(&Config{
-		User:         "happyxhw",
-		Password:     "808258XXxx",
-		Host:         "127.0.0.1",
-		Port:         5432,
-		DB:           "mydb",
-		MaxIdleConns: 10,
-		MaxOpenConns: 10,
-		Logger:       l.WithOptions(zap.AddCallerSkip(3), zap.AddCaller()),
+type testRow struct {
+	ID   int64
+	Name string
+}
+
+func TestSqliteMemory(t *testing.T) {
+	logs := loggertest.Observe(t)
+	c := &Config{
+		DB:           t.Name(),
+		Memory:       true,
+		MaxIdleConns: 2,
+		MaxOpenConns: 2,
 		Level:        "info",
+	}
+	db, err := NewSqliteDB(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Close(db)
+	if err := db.AutoMigrate(&testRow{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&testRow{Name: "a"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// a second pool sees the same database
+	other, err := NewSqliteDB(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Close(other)
+	var count int64
+	if err := other.Model(&testRow{}).Count(&count).Error; err != nil || count != 1 {
+		t.Errorf("count %d, err %v", count, err)
+	}
+	logs.AssertLogged(t, zapcore.InfoLevel, "trace")
+
+	sqlDB, _ := db.DB()
+	if stats := sqlDB.Stats(); stats.MaxOpenConnections != 2 {
+		t.Errorf("max open conns %d", stats.MaxOpenConnections)
+	}
+}
+
+func TestSqliteMemoryIsolated(t *testing.T) {
+	a, err := NewSqliteDB(&Config{DB: "a", Memory: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Close(a)
+	b, err := NewSqliteDB(&Config{DB: "b", Memory: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Close(b)
+	if err := a.AutoMigrate(&testRow{}); err != nil {
+		t.Fatal(err)
+	}
+	if b.Migrator().HasTable(&testRow{}) {
+		t.Error("table visible from another in-memory database")
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	if err := config.Validate(&Config{DB: t.Name(), Memory: true}); err != nil {
+		t.Errorf("sqlite config: %v", err)
+	}
+	if _, err := NewSqliteDB(&Config{}); err == nil {
+		t.Error("sqlite without file")
+	}
+	if _, err := NewMysqlDB(&Config{DB: "app"}); err == nil || err.Error() != "user required" {
+		t.Errorf("mysql without user: %v", err)
+	}
+}
+
+func TestReplicas(t *testing.T) {
+	logs := loggertest.Observe(t)
+	names := []string{t.Name() + "-primary", t.Name() + "-r1", t.Name() + "-r2"}
+	// keep the in-memory databases alive and mark each one
+	for _, name := range names {
+		db, err := NewSqliteDB(&Config{DB: name, Memory: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer Close(db)
+		if err := db.AutoMigrate(&testRow{}); err != nil {
+			t.Fatal(err)
+		}
+		db.Create(&testRow{Name: name})
+	}
+	db, err := NewSqliteDB(&Config{
+		DB:                  names[0],
+		Memory:              true,
+		Replicas:            []ReplicaConfig{{DB: names[1]}, {DB: names[2]}},
+		Policy:              RoundRobinPolicy,
+		HealthCheckInterval: time.Hour,
 	})
 	if err != nil {
+		t.Fatal(err)
+	}
+	defer Close(db)
+
+	read := func(db *gorm.DB) string {
+		var row testRow
+		if err := db.Order("id").First(&row).Error; err != nil {
+			t.Fatal(err)
+		}
+		return row.Name
+	}
+	if a, b := read(db), read(db); a == names[0] || b == names[0] || a == b {
+		t.Errorf("reads %s, %s", a, b)
+	}
+	if name := read(db.WithContext(WithPrimary(context.Background()))); name != names[0] {
+		t.Errorf("primary read %s", name)
+	}
+	_ = db.Transaction(func(tx *gorm.DB) error {
+		if name := read(tx); name != names[0] {
+			t.Errorf("transaction read %s", name)
+		}
+		return nil
+	})
+	if err := db.Create(&testRow{Name: "new"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	// a chained statement reused after a read begins its transaction on the primary
+	stmt := db.Where("name <> ?", "")
+	var rows []testRow
+	stmt.Find(&rows)
+	err = stmt.Transaction(func(tx *gorm.DB) error {
+		return tx.Exec("INSERT INTO test_rows (name) VALUES (?)", "reused").Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	db.WithContext(WithPrimary(context.Background())).Model(&testRow{}).Count(&count)
+	if count != 3 {
+		t.Errorf("primary rows %d", count)
+	}
+
+	r := db.Config.Plugins[resolverName].(*resolver)
+	_ = r.replicas[0].db.Close()
+	r.check()
+	if status := Replicas(db); status[0].Healthy || !status[1].Healthy {
+		t.Errorf("status %+v", status)
+	}
+	logs.AssertLogged(t, zapcore.WarnLevel, "replica ejected", zap.String("replica", names[1]))
+	for i := 0; i < 3; i++ {
+		if name := read(db); name != names[2] {
+			t.Errorf("read %s after ejection", name)
+		}
+	}
+	_ = r.replicas[1].db.Close()
+	r.check()
+	if name := read(db); name != names[0] {
+		t.Errorf("read %s without replica", name)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	db, err := NewSqliteDB(&Config{DB: t.Name(), Memory: true, MaxIdleConns: 3, MaxOpenConns: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&testRow{}); err != nil {
+		t.Fatal(err)
+	}
+	db.Create(&[]testRow{{Name: "a"}, {Name: "b"}})
+	var rows []testRow
+	db.Find(&rows)
+	db.Table("missing").Find(&rows)
+
+	if n := testutil.ToFloat64(queryRows.WithLabelValues(t.Name(), "create", "test_rows")); n != 2 {
+		t.Errorf("created rows %v", n)
+	}
+	if n := testutil.ToFloat64(queryErrors.WithLabelValues(t.Name(), "query", "missing")); n != 1 {
+		t.Errorf("query errors %v", n)
+	}
+	want := fmt.Sprintf(`
+# HELP dbgo_pool_max_open_connections Maximum number of open connections.
+# TYPE dbgo_pool_max_open_connections gauge
+dbgo_pool_max_open_connections{addr=":memory:",db=%q,instance="primary"} 3
+`, t.Name())
+	stats := db.Config.Plugins[metricsName].(*metrics).stats
+	if err := testutil.CollectAndCompare(stats, strings.NewReader(want), "dbgo_pool_max_open_connections"); err != nil {
 		t.Error(err)
-		t.FailNow()
 	}
-	db.Exec("select idx from t_test")
+
+	// a second pool with the same labels keeps the stats of the first one
+	other, err := NewSqliteDB(&Config{DB: t.Name(), Memory: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = Close(other)
+	if !prometheus.Unregister(stats) || prometheus.Register(stats) != nil {
+		t.Error("pool stats replaced")
+	}
+
+	_ = Close(db)
+	if prometheus.Unregister(stats) {
+		t.Error("pool stats still registered")
+	}
+}
+
+func TestWithTx(t *testing.T) {
+	ctx := context.Background()
+	db, err := NewSqliteDB(&Config{DB: t.Name(), Memory: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Close(db)
+	if err := db.AutoMigrate(&testRow{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var hooks []string
+	attempts := 0
+	err = WithTx(ctx, db, &TxOptions{MinBackoff: time.Millisecond}, func(tx *gorm.DB) error {
+		attempts++
+		if err := tx.Create(&testRow{Name: "outer"}).Error; err != nil {
+			return err
+		}
+		AfterCommit(tx, func() { hooks = append(hooks, "outer") })
+		_ = WithTx(ctx, tx, nil, func(tx *gorm.DB) error {
+			tx.Create(&testRow{Name: "dropped"})
+			AfterCommit(tx, func() { hooks = append(hooks, "dropped") })
+			return errors.New("rollback to savepoint")
+		})
+		if err := WithTx(ctx, tx, nil, func(tx *gorm.DB) error {
+			AfterCommit(tx, func() { hooks = append(hooks, "inner") })
+			return tx.Create(&testRow{Name: "inner"}).Error
+		}); err != nil {
+			return err
+		}
+		if attempts == 1 {
+			return fmt.Errorf("insert: %w", &mysql.MySQLError{Number: 1213, Message: "Deadlock found"})
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	db.Model(&testRow{}).Order("id").Pluck("name", &names)
+	if attempts != 2 || strings.Join(names, ",") != "outer,inner" || strings.Join(hooks, ",") != "outer,inner" {
+		t.Errorf("attempts %d, rows %v, hooks %v", attempts, names, hooks)
+	}
+
+	attempts = 0
+	err = WithTx(ctx, db, &TxOptions{MaxRetries: 1, MinBackoff: time.Millisecond}, func(tx *gorm.DB) error {
+		attempts++
+		return &pgconn.PgError{Code: "40001"}
+	})
+	if !IsRetryable(err) || attempts != 2 {
+		t.Errorf("attempts %d, err %v", attempts, err)
+	}
+	attempts = 0
+	_ = WithTx(ctx, db, nil, func(tx *gorm.DB) error {
+		attempts++
+		return errors.New("not retryable")
+	})
+	if attempts != 1 {
+		t.Errorf("attempts %d", attempts)
+	}
+
+	_ = db.Transaction(func(tx *gorm.DB) error {
+		if err := WithTx(ctx, tx, nil, func(tx *gorm.DB) error { return nil }); err != ErrExternalTx {
+			t.Errorf("external transaction: %v", err)
+		}
+		return nil
+	})
+}
+
+func TestLogger(t *testing.T) {
+	logs := loggertest.ObserveLevel(t, zapcore.DebugLevel)
+	db, err := NewSqliteDB(&Config{
+		DB:                   t.Name(),
+		Memory:               true,
+		Level:                "warn",
+		SlowThreshold:        time.Nanosecond,
+		Parameterized:        true,
+		IgnoreRecordNotFound: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Close(db)
+	if err := db.AutoMigrate(&testRow{}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := logger.WithRequestID(context.Background(), "req-1")
+	var row testRow
+	db.WithContext(ctx).Where("name = ?", "secret-name").Find(&row)
+
+	slow := logs.Find(zapcore.WarnLevel, "slow query", zap.String("request_id", "req-1"))
+	if len(slow) == 0 {
+		t.Fatalf("no slow query logged:\n%s", logs)
+	}
+	fields := slow[len(slow)-1].ContextMap()
+	if sql := fields["sql"].(string); !strings.Contains(sql, "name = ?") || strings.Contains(sql, "secret-name") {
+		t.Errorf("sql %s", sql)
+	}
+	if caller := slow[len(slow)-1].Caller; !strings.Contains(caller.File, "db_test.go") {
+		t.Errorf("caller %s", caller)
+	}
+
+	db = db.Session(&gorm.Session{Logger: db.Logger.LogMode(gLogger.Error)})
+	if err := db.First(&row).Error; !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatal(err)
+	}
+	logs.AssertNotLogged(t, zapcore.ErrorLevel, "trace")
+
+	// the caller skip of a Config.Logger is kept
+	for skip, file := range map[int]string{0: "dbgo/logger.go", 1: "gorm.io/gorm"} {
+		core, custom := observer.New(zapcore.DebugLevel)
+		db, err := NewSqliteDB(&Config{
+			DB:            fmt.Sprintf("%s_%d", t.Name(), skip),
+			Memory:        true,
+			SlowThreshold: time.Nanosecond,
+			Logger:        zap.New(core, zap.AddCaller(), zap.AddCallerSkip(skip)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = db.AutoMigrate(&testRow{})
+		entries := custom.FilterMessage("slow query").All()
+		if len(entries) == 0 || !strings.Contains(entries[0].Caller.File, file) {
+			t.Errorf("skip %d: %v", skip, entries)
+		}
+		Close(db)
+	}
+}
+
+func TestConnectRetry(t *testing.T) {
+	logs := loggertest.Observe(t)
+	_, err := NewSqliteDB(&Config{
+		DB:             "/nonexistent/dbgo/test.db",
+		ConnectRetries: 2,
+		ConnectBackoff: time.Millisecond,
+	})
+	if err == nil {
+		t.Fatal("connected to a missing directory")
+	}
+	if n := len(logs.Find(zapcore.WarnLevel, "db connect")); n != 2 {
+		t.Errorf("%d retries logged", n)
+	}
+
+	_, err = NewSqliteDB(&Config{
+		DB:             "/nonexistent/dbgo/test.db",
+		ConnectRetries: 100,
+		ConnectBackoff: 10 * time.Millisecond,
+		ConnectTimeout: 30 * time.Millisecond,
+	})
+	if err == nil || !strings.Contains(err.Error(), "connect timeout") {
+		t.Errorf("err %v", err)
+	}
+}
+
+// poolsDialector record the pools opened by its dialector
+type poolsDialector struct {
+	gorm.Dialector
+	pools []*sql.DB
+}
+
+func (d *poolsDialector) Initialize(db *gorm.DB) error {
+	err := d.Dialector.Initialize(db)
+	if pool, ok := db.ConnPool.(*sql.DB); ok {
+		d.pools = append(d.pools, pool)
+	}
+	return err
+}
+
+func TestConnectClose(t *testing.T) {
+	c := &Config{DB: "/nonexistent/dbgo/test.db", ConnectRetries: 2, ConnectBackoff: time.Millisecond}
+	d := &poolsDialector{Dialector: sqlite.Open(c.DB)}
+	if _, err := connect(d, &gorm.Config{Logger: gLogger.Discard}, c); err == nil {
+		t.Fatal("connected to a missing directory")
+	}
+	if len(d.pools) != 3 {
+		t.Fatalf("%d attempts", len(d.pools))
+	}
+	for _, pool := range d.pools {
+		if err := pool.Ping(); err == nil || err.Error() != "sql: database is closed" {
+			t.Errorf("pool left open: %v", err)
+		}
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	logs := loggertest.Observe(t)
+	db, err := NewSqliteDB(&Config{
+		DB:                  t.Name(),
+		Memory:              true,
+		MaxIdleConns:        2,
+		MaxOpenConns:        2,
+		MonitorInterval:     time.Hour,
+		SaturationThreshold: 0.5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Close(db)
+
+	h, err := HealthCheck(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.MaxOpen != 2 || h.InUse != 0 || h.Saturation != 0 {
+		t.Errorf("health %+v", h)
+	}
+	if degraded, reason := Degraded(db); degraded {
+		t.Errorf("degraded: %s", reason)
+	}
+
+	m := db.Config.Plugins[monitorName].(*monitor)
+	sqlDB, _ := db.DB()
+	conn, err := sqlDB.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.check()
+	if degraded, reason := Degraded(db); !degraded || !strings.Contains(reason, "pool saturated: 1/2") {
+		t.Errorf("degraded %v: %s", degraded, reason)
+	}
+	logs.AssertLogged(t, zapcore.WarnLevel, "db degraded", zap.String("db", t.Name()))
+	if v := testutil.ToFloat64(degradedGauge.WithLabelValues(t.Name())); v != 1 {
+		t.Errorf("gauge %v", v)
+	}
+
+	_ = conn.Close()
+	m.check()
+	if degraded, _ := Degraded(db); degraded {
+		t.Error("still degraded")
+	}
+	logs.AssertLogged(t, zapcore.InfoLevel, "db recovered", zap.String("db", t.Name()))
+
+	_ = sqlDB.Close()
+	m.check()
+	if degraded, reason := Degraded(db); !degraded || !strings.HasPrefix(reason, "ping: ") {
+		t.Errorf("degraded %v: %s", degraded, reason)
+	}
+}
+
+func TestDSN(t *testing.T) {
+	c := &Config{
+		User:         "app",
+		Password:     "p@ss word",
+		DB:           "app",
+		SSLMode:      "verify-full",
+		Timezone:     "Asia/Shanghai",
+		DialTimeout:  1500 * time.Millisecond,
+		ReadTimeout:  time.Second,
+		WriteTimeout: 2 * time.Second,
+		Params:       map[string]string{"sql_mode": "'TRADITIONAL'"},
+	}
+	dsn, err := mysqlDSN(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	my, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if my.Addr != "127.0.0.1:3306" || my.Passwd != c.Password || my.TLSConfig != "true" ||
+		my.Loc.String() != "Asia/Shanghai" || !my.ParseTime || my.Timeout != c.DialTimeout ||
+		my.ReadTimeout != time.Second || my.WriteTimeout != 2*time.Second ||
+		my.Params["charset"] != "utf8mb4" || my.Params["sql_mode"] != "'TRADITIONAL'" {
+		t.Errorf("mysql %s", dsn)
+	}
+	if _, err := mysqlDSN(&Config{Timezone: "Nowhere/Nothing"}); err == nil {
+		t.Error("unknown time zone")
+	}
+	if _, err := mysqlDSN(&Config{SSLMode: "verify-ca", SSLRootCert: "/nonexistent/ca.pem"}); err == nil {
+		t.Error("missing CA file")
+	}
+
+	if tls, err := mysqlTLS(&Config{SSLMode: "prefer", SSLRootCert: "/nonexistent/ca.pem"}); tls != "preferred" || err != nil {
+		t.Errorf("prefer %q: %v", tls, err)
+	}
+	if _, err := mysqlTLS(&Config{SSLMode: "prefer", SSLCert: "/nonexistent/client.pem"}); err == nil {
+		t.Error("prefer with a client certificate")
+	}
+	a := &Config{SSLMode: "verify-full", SSLRootCert: "/etc/ca.pem", SSLCert: "/etc/a.pem", SSLKey: "/etc/a.key"}
+	b := *a
+	b.SSLCert, b.SSLKey = "/etc/b.pem", "/etc/b.key"
+	if tlsName(a) == tlsName(&b) {
+		t.Error("same tls config name for different client certificates")
+	}
+
+	c.SSLMode = ""
+	c.Schema = "tenant, public"
+	c.ApplicationName = "api"
+	c.Params = map[string]string{"statement_timeout": "5000"}
+	pg, err := pgconn.ParseConfig(postgresDSN(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pg.Port != 5432 || pg.Password != c.Password || pg.TLSConfig != nil || pg.ConnectTimeout != 2*time.Second ||
+		pg.RuntimeParams["search_path"] != "tenant, public" || pg.RuntimeParams["application_name"] != "api" ||
+		pg.RuntimeParams["TimeZone"] != "Asia/Shanghai" || pg.RuntimeParams["statement_timeout"] != "5000" {
+		t.Errorf("postgres %s: %+v", postgresDSN(c), pg)
+	}
+	c.SSLMode = "verify-full"
+	if pg, err := pgconn.ParseConfig(postgresDSN(c)); err != nil || pg.TLSConfig == nil || pg.TLSConfig.InsecureSkipVerify {
+		t.Errorf("postgres %s: %v", postgresDSN(c), err)
+	}
 }
@@ -0,0 +1,216 @@
+package dbgo
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	defaultMysqlPort    = 3306
+	defaultPostgresPort = 5432
+	defaultCharset      = "utf8mb4"
+	defaultSSLMode      = "disable"
+)
+
+// mysqlDSN the go-sql-driver dsn of c
+func mysqlDSN(c *Config) (string, error) {
+	cfg := mysql.NewConfig()
+	cfg.User = c.User
+	cfg.Passwd = c.Password
+	cfg.Net = "tcp"
+	cfg.Addr = net.JoinHostPort(host(c), strconv.Itoa(port(c, defaultMysqlPort)))
+	cfg.DBName = c.DB
+	cfg.ParseTime = true
+	cfg.Timeout = c.DialTimeout
+	cfg.ReadTimeout = c.ReadTimeout
+	cfg.WriteTimeout = c.WriteTimeout
+	if c.Timezone != "" {
+		loc, err := time.LoadLocation(c.Timezone)
+		if err != nil {
+			return "", err
+		}
+		cfg.Loc = loc
+	}
+	tlsConfig, err := mysqlTLS(c)
+	if err != nil {
+		return "", err
+	}
+	cfg.TLSConfig = tlsConfig
+	charset := c.Charset
+	if charset == "" {
+		charset = defaultCharset
+	}
+	cfg.Params = map[string]string{"charset": charset}
+	for k, v := range c.Params {
+		cfg.Params[k] = v
+	}
+	return cfg.FormatDSN(), nil
+}
+
+// mysqlTLS the tls param of c: a name of the driver if no certificate is set, a registered config else.
+// The driver falls back to plaintext only with its preferred config, allow and prefer do not verify the server
+// and cannot send a client certificate
+func mysqlTLS(c *Config) (string, error) {
+	mode := c.SSLMode
+	noCert := c.SSLRootCert == "" && c.SSLCert == ""
+	switch {
+	case mode == "" || mode == "disable":
+		return "", nil
+	case (mode == "allow" || mode == "prefer") && c.SSLCert != "":
+		return "", fmt.Errorf("mysql: ssl_mode %s without fallback to plaintext with a client certificate, use require", mode)
+	case mode == "allow" || mode == "prefer":
+		return "preferred", nil
+	case mode == "require" && noCert:
+		return "skip-verify", nil
+	case mode == "verify-full" && noCert:
+		return "true", nil
+	}
+
+	cfg := &tls.Config{ServerName: host(c)}
+	if c.SSLRootCert != "" {
+		pem, err := ioutil.ReadFile(c.SSLRootCert)
+		if err != nil {
+			return "", err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return "", fmt.Errorf("no certificate in %s", c.SSLRootCert)
+		}
+	}
+	if c.SSLCert != "" {
+		cert, err := tls.LoadX509KeyPair(c.SSLCert, c.SSLKey)
+		if err != nil {
+			return "", err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	switch mode {
+	case "require":
+		cfg.InsecureSkipVerify = true
+	case "verify-ca":
+		// verify the chain but not the host name
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyPeerCertificate = verifyChain(cfg.RootCAs)
+	}
+	name := tlsName(c)
+	if err := mysql.RegisterTLSConfig(name, cfg); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// tlsName name of the tls config of c registered in the driver, global, it hashes all the tls settings
+// so that the config of another pool never replaces it
+func tlsName(c *Config) string {
+	h := fnv.New64a()
+	for _, s := range []string{c.SSLMode, host(c), c.SSLRootCert, c.SSLCert, c.SSLKey} {
+		_, _ = h.Write([]byte(s))
+		_, _ = h.Write([]byte{0})
+	}
+	return fmt.Sprintf("dbgo-%x", h.Sum64())
+}
+
+// verifyChain verify the certificates of the server against roots, whatever their host names
+func verifyChain(roots *x509.CertPool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("no server certificate")
+		}
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			certs[i] = cert
+		}
+		opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+		for _, cert := range certs[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		_, err := certs[0].Verify(opts)
+		return err
+	}
+}
+
+// postgresDSN the pgx key=value dsn of c
+func postgresDSN(c *Config) string {
+	params := map[string]string{
+		"host":     host(c),
+		"port":     strconv.Itoa(port(c, defaultPostgresPort)),
+		"user":     c.User,
+		"password": c.Password,
+		"dbname":   c.DB,
+		"sslmode":  c.SSLMode,
+	}
+	if params["sslmode"] == "" {
+		params["sslmode"] = defaultSSLMode
+	}
+	optional := map[string]string{
+		"sslrootcert":      c.SSLRootCert,
+		"sslcert":          c.SSLCert,
+		"sslkey":           c.SSLKey,
+		"TimeZone":         c.Timezone,
+		"search_path":      c.Schema,
+		"application_name": c.ApplicationName,
+	}
+	if c.DialTimeout > 0 {
+		// seconds, rounded up as 0 means no timeout
+		optional["connect_timeout"] = strconv.Itoa(int(math.Ceil(c.DialTimeout.Seconds())))
+	}
+	for k, v := range optional {
+		if v != "" {
+			params[k] = v
+		}
+	}
+	for k, v := range c.Params {
+		params[k] = v
+	}
+
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + quoteDSNValue(params[k])
+	}
+	return strings.Join(pairs, " ")
+}
+
+// quoteDSNValue quote v for a key=value dsn if it is empty or has spaces, quotes or backslashes
+func quoteDSNValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, ` '\`) {
+		return v
+	}
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `'`, `\'`)
+	return "'" + v + "'"
+}
+
+func host(c *Config) string {
+	if c.Host == "" {
+		return "127.0.0.1"
+	}
+	return c.Host
+}
+
+func port(c *Config, defaultPort int) int {
+	if c.Port == 0 {
+		return defaultPort
+	}
+	return c.Port
+}
@@ -0,0 +1,239 @@
+package dbgo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/happyxhw/gopkg/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	monitorName                = "dbgo:monitor"
+	defaultConnectMinBackoff   = 100 * time.Millisecond
+	defaultConnectBackoff      = 5 * time.Second
+	defaultSaturationThreshold = 0.9
+	// maxPingTimeout timeout of the pings of the health checks, a hung ping must not hide the degraded state
+	maxPingTimeout = 2 * time.Second
+)
+
+var (
+	degradedGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dbgo_degraded",
+		Help: "1 if the last health check of the db failed or found the pool saturated.",
+	}, []string{"db"})
+
+	registerDegradedOnce sync.Once
+)
+
+// connect open d, retrying ConnectRetries times with a backoff, within ConnectTimeout,
+// which bounds the retries, an attempt itself is bounded by DialTimeout
+func connect(d gorm.Dialector, c *gorm.Config, dbConfig *Config) (*gorm.DB, error) {
+	ctx := context.Background()
+	if dbConfig.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dbConfig.ConnectTimeout)
+		defer cancel()
+	}
+	maxBackoff := dbConfig.ConnectBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultConnectBackoff
+	}
+	for retry := 0; ; retry++ {
+		db, err := gorm.Open(d, c)
+		if err == nil {
+			return db, nil
+		}
+		// a failed ping still opened the pool
+		if db != nil {
+			if sqlDB, dbErr := db.DB(); dbErr == nil {
+				_ = sqlDB.Close()
+			}
+		}
+		if retry >= dbConfig.ConnectRetries {
+			return nil, err
+		}
+		backoff := utils.RetryBackoff(retry, defaultConnectMinBackoff, maxBackoff)
+		dbLogger(dbConfig).Warn("db connect",
+			zap.String("db", dbConfig.DB), zap.Int("retry", retry+1),
+			zap.Duration("backoff", backoff), zap.Error(err))
+		if utils.Sleep(ctx, backoff) != nil {
+			return nil, fmt.Errorf("connect timeout after %d retries: %w", retry+1, err)
+		}
+	}
+}
+
+// Health result of a HealthCheck
+type Health struct {
+	// Latency of the ping
+	Latency time.Duration
+	// Saturation share of the max open connections in use, 0 if unlimited
+	Saturation float64
+	// InUse, Idle and MaxOpen connections of the pool
+	InUse   int
+	Idle    int
+	MaxOpen int
+	// WaitCount and WaitDuration total waits for a connection
+	WaitCount    int64
+	WaitDuration time.Duration
+	// Replicas status of the replicas, if any
+	Replicas []ReplicaStatus
+}
+
+// HealthCheck ping db and report its pool usage, the error is the one of the ping
+func HealthCheck(ctx context.Context, db *gorm.DB) (*Health, error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	err = sqlDB.PingContext(ctx)
+	stats := sqlDB.Stats()
+	h := &Health{
+		Latency:      time.Since(start),
+		InUse:        stats.InUse,
+		Idle:         stats.Idle,
+		MaxOpen:      stats.MaxOpenConnections,
+		WaitCount:    stats.WaitCount,
+		WaitDuration: stats.WaitDuration,
+		Replicas:     Replicas(db),
+	}
+	if stats.MaxOpenConnections > 0 {
+		h.Saturation = float64(stats.InUse) / float64(stats.MaxOpenConnections)
+	}
+	return h, err
+}
+
+// Degraded report whether the last check of the monitor of db failed, with the reason,
+// false if db has no monitor
+func Degraded(db *gorm.DB) (bool, string) {
+	m, ok := db.Config.Plugins[monitorName].(*monitor)
+	if !ok {
+		return false, ""
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.reason != "", m.reason
+}
+
+// monitor gorm plugin running HealthCheck in the background
+type monitor struct {
+	db         string
+	logger     *zap.Logger
+	interval   time.Duration
+	saturation float64
+
+	mu     sync.Mutex
+	reason string
+
+	gdb      *gorm.DB
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	doneCh   chan struct{}
+}
+
+func newMonitor(dbConfig *Config) *monitor {
+	m := &monitor{
+		db:         dbConfig.DB,
+		logger:     dbLogger(dbConfig),
+		interval:   dbConfig.MonitorInterval,
+		saturation: dbConfig.SaturationThreshold,
+		stopCh:     make(chan struct{}),
+		doneCh:     make(chan struct{}),
+	}
+	if m.saturation <= 0 {
+		m.saturation = defaultSaturationThreshold
+	}
+	return m
+}
+
+func (m *monitor) Name() string {
+	return monitorName
+}
+
+func (m *monitor) Initialize(db *gorm.DB) error {
+	registerDegradedOnce.Do(func() {
+		prometheus.MustRegister(degradedGauge)
+	})
+	m.gdb = db
+	m.check()
+	go m.run()
+	return nil
+}
+
+func (m *monitor) run() {
+	defer close(m.doneCh)
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.check()
+		case <-m.stopCh:
+			return
+		}
+	}
+}
+
+// check run a HealthCheck and log the changes of the degraded state
+func (m *monitor) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout(m.interval))
+	defer cancel()
+	h, err := HealthCheck(ctx, m.gdb)
+	reason := degradedReason(h, err, m.saturation)
+
+	m.mu.Lock()
+	old := m.reason
+	m.reason = reason
+	m.mu.Unlock()
+
+	switch {
+	case reason != "" && reason != old:
+		m.logger.Warn("db degraded", zap.String("db", m.db), zap.String("reason", reason), zap.Any("health", h))
+		degradedGauge.WithLabelValues(m.db).Set(1)
+	case reason == "" && old != "":
+		m.logger.Info("db recovered", zap.String("db", m.db), zap.Duration("latency", h.Latency))
+		degradedGauge.WithLabelValues(m.db).Set(0)
+	case reason == "":
+		degradedGauge.WithLabelValues(m.db).Set(0)
+	}
+}
+
+// pingTimeout the timeout of the pings of checks run every interval
+func pingTimeout(interval time.Duration) time.Duration {
+	if interval < maxPingTimeout {
+		return interval
+	}
+	return maxPingTimeout
+}
+
+func degradedReason(h *Health, err error, saturation float64) string {
+	if err != nil {
+		return "ping: " + err.Error()
+	}
+	var reasons []string
+	if h.Saturation >= saturation {
+		reasons = append(reasons, fmt.Sprintf("pool saturated: %d/%d in use", h.InUse, h.MaxOpen))
+	}
+	for _, r := range h.Replicas {
+		if !r.Healthy {
+			reasons = append(reasons, "replica "+r.Name+" ejected")
+		}
+	}
+	return strings.Join(reasons, "; ")
+}
+
+func (m *monitor) close() {
+	m.stopOnce.Do(func() {
+		close(m.stopCh)
+		if m.gdb != nil {
+			<-m.doneCh
+		}
+		degradedGauge.DeleteLabelValues(m.db)
+	})
+}
@@ -2,22 +2,46 @@ package dbgo
 
 import (
 	"context"
+	"errors"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"time"
 
+	"github.com/happyxhw/gopkg/logger"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 	gLogger "gorm.io/gorm/logger"
 )
 
+const (
+	defaultSlowThreshold = time.Second
+	loggerName           = "dbgo:logger"
+)
+
+// sourceDir directory of dbgo, skipped with the gorm sources when looking for the caller
+var sourceDir = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Dir(file) + string(filepath.Separator)
+}()
+
+type rawSQLKey struct{}
+
 type gormLogger struct {
 	logger        *zap.Logger
 	LogLevel      gLogger.LogLevel
 	SlowThreshold time.Duration
+	// Parameterized log the sql with its placeholders
+	Parameterized bool
+	// IgnoreRecordNotFound do not log gorm.ErrRecordNotFound
+	IgnoreRecordNotFound bool
+	// skipCaller skip the gorm and dbgo frames, the caller skip of a Config.Logger is kept as is
+	skipCaller bool
 }
 
-func newLogger(l *zap.Logger, level string) gormLogger {
+func newLogger(l *zap.Logger, c *Config) gormLogger {
 	ll := gLogger.Warn
-	switch strings.ToLower(level) {
+	switch strings.ToLower(c.Level) {
 	case "info":
 		ll = gLogger.Info
 	case "warn":
@@ -28,56 +52,125 @@ func newLogger(l *zap.Logger, level string) gormLogger {
 		ll = gLogger.Silent
 	}
 	gl := gormLogger{
-		logger:        l,
-		LogLevel:      ll,
-		SlowThreshold: time.Second,
+		logger:               l,
+		LogLevel:             ll,
+		SlowThreshold:        c.SlowThreshold,
+		Parameterized:        c.Parameterized,
+		IgnoreRecordNotFound: c.IgnoreRecordNotFound,
+		skipCaller:           c.Logger == nil,
+	}
+	if gl.SlowThreshold == 0 {
+		gl.SlowThreshold = defaultSlowThreshold
 	}
 	return gl
 }
 
 func (gl gormLogger) LogMode(level gLogger.LogLevel) gLogger.Interface {
-	return gormLogger{
-		logger:        gl.logger,
-		LogLevel:      level,
-		SlowThreshold: gl.SlowThreshold,
+	gl.LogLevel = level
+	return gl
+}
+
+// entry return the logger with the ContextInfo of ctx,
+// the caller of the default logger, if enabled, is the first frame out of gorm and dbgo
+func (gl gormLogger) entry(ctx context.Context) *zap.Logger {
+	l := gl.logger
+	if gl.skipCaller {
+		l = l.WithOptions(zap.AddCallerSkip(callerSkip()))
 	}
+	return l.With(logger.ContextFields(ctx)...)
 }
 
-func (gl gormLogger) Info(_ context.Context, s string, i ...interface{}) {
+func (gl gormLogger) Info(ctx context.Context, s string, i ...interface{}) {
 	if gl.LogLevel < gLogger.Info {
 		return
 	}
-	gl.logger.Sugar().Infof(s, i...)
+	gl.entry(ctx).Sugar().Infof(s, i...)
 }
 
-func (gl gormLogger) Warn(_ context.Context, s string, i ...interface{}) {
+func (gl gormLogger) Warn(ctx context.Context, s string, i ...interface{}) {
 	if gl.LogLevel < gLogger.Warn {
 		return
 	}
-	gl.logger.Sugar().Warnf(s, i...)
+	gl.entry(ctx).Sugar().Warnf(s, i...)
 }
 
-func (gl gormLogger) Error(_ context.Context, s string, i ...interface{}) {
+func (gl gormLogger) Error(ctx context.Context, s string, i ...interface{}) {
 	if gl.LogLevel < gLogger.Error {
 		return
 	}
-	gl.logger.Sugar().Errorf(s, i...)
+	gl.entry(ctx).Sugar().Errorf(s, i...)
 }
 
-func (gl gormLogger) Trace(_ context.Context, begin time.Time, fc func() (string, int64), err error) {
+func (gl gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
 	if gl.LogLevel <= 0 {
 		return
 	}
 	elapsed := time.Since(begin)
-	switch {
-	case err != nil && gl.LogLevel >= gLogger.Error:
-		sql, rows := fc()
-		gl.logger.Error("trace", zap.Error(err), zap.String("elapsed", elapsed.String()), zap.Int64("rows", rows), zap.String("sql", sql))
-	case gl.SlowThreshold != 0 && elapsed > gl.SlowThreshold && gl.LogLevel >= gLogger.Warn:
+	sqlRows := func() (string, int64) {
 		sql, rows := fc()
-		gl.logger.Warn("trace", zap.String("elapsed", elapsed.String()), zap.Int64("rows", rows), zap.String("sql", sql))
+		if raw, ok := ctx.Value(rawSQLKey{}).(string); ok && gl.Parameterized {
+			sql = raw
+		}
+		return logger.DefaultRedactor().SQL(sql), rows
+	}
+	switch {
+	case err != nil && gl.LogLevel >= gLogger.Error && !(gl.IgnoreRecordNotFound && errors.Is(err, gorm.ErrRecordNotFound)):
+		sql, rows := sqlRows()
+		gl.entry(ctx).Error("trace", zap.Error(err), zap.String("elapsed", elapsed.String()), zap.Int64("rows", rows), zap.String("sql", sql))
+	case gl.SlowThreshold > 0 && elapsed > gl.SlowThreshold && gl.LogLevel >= gLogger.Warn:
+		sql, rows := sqlRows()
+		gl.entry(ctx).Warn("slow query", zap.Duration("threshold", gl.SlowThreshold), zap.String("elapsed", elapsed.String()), zap.Int64("rows", rows), zap.String("sql", sql))
 	case gl.LogLevel >= gLogger.Info:
-		sql, rows := fc()
-		gl.logger.Info("trace", zap.String("elapsed", elapsed.String()), zap.Int64("rows", rows), zap.String("sql", sql))
+		sql, rows := sqlRows()
+		gl.entry(ctx).Info("trace", zap.String("elapsed", elapsed.String()), zap.Int64("rows", rows), zap.String("sql", sql))
+	}
+}
+
+// rawSQL gorm plugin passing the sql with its placeholders to Trace through the statement context
+type rawSQL struct{}
+
+func (rawSQL) Name() string {
+	return loggerName
+}
+
+func (rawSQL) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	errs := []error{
+		cb.Create().After("*").Register(loggerName, setRawSQL),
+		cb.Query().After("*").Register(loggerName, setRawSQL),
+		cb.Update().After("*").Register(loggerName, setRawSQL),
+		cb.Delete().After("*").Register(loggerName, setRawSQL),
+		cb.Row().After("*").Register(loggerName, setRawSQL),
+		cb.Raw().After("*").Register(loggerName, setRawSQL),
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setRawSQL(db *gorm.DB) {
+	ctx := db.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	db.Statement.Context = context.WithValue(ctx, rawSQLKey{}, db.Statement.SQL.String())
+}
+
+// callerSkip return the frames between the gormLogger method calling entry and the first caller
+// out of gorm and dbgo, tests excluded
+func callerSkip() int {
+	for i := 2; i < 30; i++ {
+		_, file, _, ok := runtime.Caller(i)
+		if !ok {
+			return 0
+		}
+		if strings.HasSuffix(file, "_test.go") ||
+			(!strings.Contains(file, "gorm.io/") && !strings.HasPrefix(file, sourceDir)) {
+			return i - 2
+		}
 	}
+	return 0
 }
@@ -0,0 +1,202 @@
+package dbgo
+
+import (
+	"database/sql"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	metricsName     = "dbgo:metrics"
+	metricsStartKey = "dbgo:metrics_start"
+	// primaryInstance instance label of the primary pool, the replicas are labelled by name
+	primaryInstance = "primary"
+)
+
+var (
+	queryLabels = []string{"db", "operation", "table"}
+
+	queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dbgo_query_duration_seconds",
+		Help:    "Duration of the gorm statements.",
+		Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, queryLabels)
+	queryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dbgo_query_errors_total",
+		Help: "Failed gorm statements, record not found excluded.",
+	}, queryLabels)
+	queryRows = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dbgo_query_rows_total",
+		Help: "Rows affected or returned by the gorm statements.",
+	}, queryLabels)
+
+	registerOnce sync.Once
+)
+
+// metrics gorm plugin recording the duration, errors and rows of the statements,
+// labelled by operation (create, query, update, delete, row, raw) and table
+type metrics struct {
+	db    string
+	stats *statsCollector
+}
+
+func newMetrics(dbConfig *Config, dbType DBType, sqlDB *sql.DB) *metrics {
+	return &metrics{
+		db:    dbConfig.DB,
+		stats: registerStats(dbLogger(dbConfig), dbConfig.DB, poolAddr(dbConfig, dbType), primaryInstance, sqlDB),
+	}
+}
+
+func (m *metrics) Name() string {
+	return metricsName
+}
+
+func (m *metrics) Initialize(db *gorm.DB) error {
+	registerOnce.Do(func() {
+		prometheus.MustRegister(queryDuration, queryErrors, queryRows)
+	})
+	cb := db.Callback()
+	start, end := metricsName+"_start", metricsName+"_end"
+	errs := []error{
+		cb.Create().Before("*").Register(start, m.start),
+		cb.Create().After("*").Register(end, m.end("create")),
+		cb.Query().Before("*").Register(start, m.start),
+		cb.Query().After("*").Register(end, m.end("query")),
+		cb.Update().Before("*").Register(start, m.start),
+		cb.Update().After("*").Register(end, m.end("update")),
+		cb.Delete().Before("*").Register(start, m.start),
+		cb.Delete().After("*").Register(end, m.end("delete")),
+		cb.Row().Before("*").Register(start, m.start),
+		cb.Row().After("*").Register(end, m.end("row")),
+		cb.Raw().Before("*").Register(start, m.start),
+		cb.Raw().After("*").Register(end, m.end("raw")),
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *metrics) start(db *gorm.DB) {
+	db.InstanceSet(metricsStartKey, time.Now())
+}
+
+func (m *metrics) end(op string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		v, ok := db.InstanceGet(metricsStartKey)
+		if !ok {
+			return
+		}
+		start, _ := v.(time.Time)
+		table := db.Statement.Table
+		if table == "" && db.Statement.Schema != nil {
+			table = db.Statement.Schema.Table
+		}
+		queryDuration.WithLabelValues(m.db, op, table).Observe(time.Since(start).Seconds())
+		if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+			queryErrors.WithLabelValues(m.db, op, table).Inc()
+		}
+		if db.RowsAffected > 0 {
+			queryRows.WithLabelValues(m.db, op, table).Add(float64(db.RowsAffected))
+		}
+	}
+}
+
+func (m *metrics) close() {
+	m.stats.unregister()
+}
+
+// poolAddr addr label of the pool of c, host:port, the sqlite file or :memory:
+func poolAddr(c *Config, dbType DBType) string {
+	switch {
+	case dbType == SqliteDB && c.Memory:
+		return ":memory:"
+	case dbType == SqliteDB:
+		return c.DB
+	case dbType == PgDB:
+		return net.JoinHostPort(host(c), strconv.Itoa(port(c, defaultPostgresPort)))
+	}
+	return net.JoinHostPort(host(c), strconv.Itoa(port(c, defaultMysqlPort)))
+}
+
+// statsCollector export the sql.DBStats of a pool
+type statsCollector struct {
+	db *sql.DB
+	// registered false if another pool with the same labels was registered first
+	registered bool
+
+	maxOpen           *prometheus.Desc
+	open              *prometheus.Desc
+	inUse             *prometheus.Desc
+	idle              *prometheus.Desc
+	waitCount         *prometheus.Desc
+	waitDuration      *prometheus.Desc
+	maxIdleClosed     *prometheus.Desc
+	maxLifetimeClosed *prometheus.Desc
+}
+
+// registerStats register the stats of the pool instance of db at addr,
+// a pool with the same labels as a registered one is not exported, the first one keeps its metrics
+func registerStats(l *zap.Logger, db, addr, instance string, sqlDB *sql.DB) *statsCollector {
+	labels := prometheus.Labels{"db": db, "addr": addr, "instance": instance}
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc("dbgo_pool_"+name, help, nil, labels)
+	}
+	c := &statsCollector{
+		db:                sqlDB,
+		maxOpen:           desc("max_open_connections", "Maximum number of open connections."),
+		open:              desc("open_connections", "Established connections, in use and idle."),
+		inUse:             desc("in_use_connections", "Connections in use."),
+		idle:              desc("idle_connections", "Idle connections."),
+		waitCount:         desc("wait_count_total", "Connections waited for."),
+		waitDuration:      desc("wait_duration_seconds_total", "Time blocked waiting for a connection."),
+		maxIdleClosed:     desc("max_idle_closed_total", "Connections closed due to the max idle connections."),
+		maxLifetimeClosed: desc("max_lifetime_closed_total", "Connections closed due to the max lifetime."),
+	}
+	if err := prometheus.Register(c); err != nil {
+		l.Warn("pool stats not exported",
+			zap.String("db", db), zap.String("addr", addr), zap.String("instance", instance), zap.Error(err))
+		return c
+	}
+	c.registered = true
+	return c
+}
+
+// unregister unregister c if it was registered
+func (c *statsCollector) unregister() {
+	if c.registered {
+		prometheus.Unregister(c)
+	}
+}
+
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.maxOpen
+	ch <- c.open
+	ch <- c.inUse
+	ch <- c.idle
+	ch <- c.waitCount
+	ch <- c.waitDuration
+	ch <- c.maxIdleClosed
+	ch <- c.maxLifetimeClosed
+}
+
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.db.Stats()
+	ch <- prometheus.MustNewConstMetric(c.maxOpen, prometheus.GaugeValue, float64(s.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.open, prometheus.GaugeValue, float64(s.OpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.inUse, prometheus.GaugeValue, float64(s.InUse))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(s.Idle))
+	ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(s.WaitCount))
+	ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, s.WaitDuration.Seconds())
+	ch <- prometheus.MustNewConstMetric(c.maxIdleClosed, prometheus.CounterValue, float64(s.MaxIdleClosed))
+	ch <- prometheus.MustNewConstMetric(c.maxLifetimeClosed, prometheus.CounterValue, float64(s.MaxLifetimeClosed))
+}
@@ -0,0 +1,175 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
+	"github.com/mattn/go-sqlite3"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	gLogger "gorm.io/gorm/logger"
+)
+
+const lockRetryInterval = 100 * time.Millisecond
+
+type migrationLock struct {
+	ID       int `gorm:"primaryKey;autoIncrement:false"`
+	LockedAt time.Time
+}
+
+// lock take the migration lock, an advisory lock on mysql and postgres, a row of the Table_lock table else,
+// the lock of a crashed instance must then be deleted by hand.
+// It returns the db to migrate with, bound to the connection holding an advisory lock
+// so that a pool of one connection does not block
+func (m *Migrator) lock(ctx context.Context) (db *gorm.DB, unlock func(), err error) {
+	lockCtx, cancel := context.WithTimeout(ctx, m.c.LockTimeout)
+	defer cancel()
+	var conn *sql.Conn
+	switch m.db.Dialector.Name() {
+	case "mysql":
+		conn, unlock, err = m.mysqlLock(lockCtx)
+	case "postgres":
+		conn, unlock, err = m.pgLock(lockCtx)
+	default:
+		if unlock, err = m.tableLock(lockCtx); err != nil {
+			return nil, nil, err
+		}
+		return m.session(ctx), unlock, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	db = m.session(ctx)
+	db.Statement.ConnPool = conn
+	return db, unlock, nil
+}
+
+// conn take a dedicated connection of the pool
+func (m *Migrator) conn(ctx context.Context) (*sql.Conn, error) {
+	sqlDB, err := m.db.DB()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("migrate: lock: %w", err)
+	}
+	return conn, nil
+}
+
+// mysqlLock GET_LOCK on a dedicated connection, released with it
+func (m *Migrator) mysqlLock(ctx context.Context) (*sql.Conn, func(), error) {
+	conn, err := m.conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	var ok int
+	timeout := int(m.c.LockTimeout / time.Second)
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", m.c.Table, timeout).Scan(&ok); err != nil {
+		_ = conn.Close()
+		return nil, nil, fmt.Errorf("migrate: lock: %w", err)
+	}
+	if ok != 1 {
+		_ = conn.Close()
+		return nil, nil, ErrLocked
+	}
+	return conn, func() {
+		if _, err := conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", m.c.Table); err != nil {
+			m.logger.Error("migrate unlock", zap.Error(err))
+		}
+		_ = conn.Close()
+	}, nil
+}
+
+// pgLock pg_try_advisory_lock on a dedicated connection, retried until the timeout
+func (m *Migrator) pgLock(ctx context.Context) (*sql.Conn, func(), error) {
+	conn, err := m.conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(m.c.Table))
+	key := int64(h.Sum64())
+	for {
+		var ok bool
+		if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&ok); err != nil {
+			_ = conn.Close()
+			return nil, nil, fmt.Errorf("migrate: lock: %w", err)
+		}
+		if ok {
+			break
+		}
+		if err := wait(ctx); err != nil {
+			_ = conn.Close()
+			return nil, nil, err
+		}
+	}
+	return conn, func() {
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key); err != nil {
+			m.logger.Error("migrate unlock", zap.Error(err))
+		}
+		_ = conn.Close()
+	}, nil
+}
+
+// tableLock insert the single row of the lock table, retried until the timeout
+func (m *Migrator) tableLock(ctx context.Context) (func(), error) {
+	table := m.c.Table + "_lock"
+	db := m.session(ctx)
+	if err := db.Table(table).AutoMigrate(&migrationLock{}); err != nil {
+		return nil, fmt.Errorf("migrate: lock: %w", err)
+	}
+	// the failed attempts are expected
+	db = db.Session(&gorm.Session{Logger: db.Logger.LogMode(gLogger.Silent)})
+	for {
+		err := db.Table(table).Create(&migrationLock{ID: 1, LockedAt: time.Now()}).Error
+		if err == nil {
+			break
+		}
+		if !isUniqueViolation(err) {
+			return nil, fmt.Errorf("migrate: lock: %w", err)
+		}
+		if err := wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return func() {
+		err := m.session(context.Background()).Table(table).Where("id = ?", 1).Delete(&migrationLock{}).Error
+		if err != nil {
+			m.logger.Error("migrate unlock", zap.Error(err))
+		}
+	}, nil
+}
+
+// isUniqueViolation report whether err is the duplicate key of a held lock
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey || sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	}
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		return myErr.Number == 1062
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "23505"
+	}
+	return false
+}
+
+// wait the next lock attempt, ErrLocked once ctx is done
+func wait(ctx context.Context) error {
+	select {
+	case <-time.After(lockRetryInterval):
+		return nil
+	case <-ctx.Done():
+		return ErrLocked
+	}
+}
@@ -0,0 +1,276 @@
+// Package migrate versioned schema migrations
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/happyxhw/gopkg/dbgo"
+	"github.com/happyxhw/gopkg/logger"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultTable       = "schema_migrations"
+	defaultLockTimeout = time.Minute
+)
+
+// ErrLocked another instance holds the migration lock
+var ErrLocked = errors.New("migrate: locked by another instance")
+
+// Migration a schema version, Up and Down run in a transaction, on mysql the ddl statements commit implicitly
+type Migration struct {
+	// Version order of the migrations, greater than 0, e.g. 1 or 20201201120000
+	Version int64
+	Name    string
+	Up      func(tx *gorm.DB) error
+	// Down revert Up, optional
+	Down func(tx *gorm.DB) error
+}
+
+// Config of a Migrator
+type Config struct {
+	// Table applied versions table, default schema_migrations
+	Table string
+	// LockTimeout max wait of the migration lock, default 1m
+	LockTimeout time.Duration
+	// DryRun log the migrations to run without running them
+	DryRun bool
+	// Logger default logger.Named("migrate")
+	Logger *zap.Logger
+}
+
+// Status of a migration
+type Status struct {
+	Version int64
+	Name    string
+	Applied bool
+	// AppliedAt zero if not applied
+	AppliedAt time.Time
+	// Missing applied but not known, e.g. after a rollback of the code
+	Missing bool
+}
+
+// Migrator run the migrations on a db
+type Migrator struct {
+	db         *gorm.DB
+	c          Config
+	logger     *zap.Logger
+	migrations []*Migration
+}
+
+type schemaMigration struct {
+	Version   int64 `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+// New return a migrator of the migrations, e.g.
+//
+//	files, err := migrate.FromFS(http.Dir("migrations"), "/")
+//	m, err := migrate.New(db, nil, files...)
+//	err = m.Up(ctx)
+func New(db *gorm.DB, c *Config, migrations ...*Migration) (*Migrator, error) {
+	m := &Migrator{db: db}
+	if c != nil {
+		m.c = *c
+	}
+	if m.c.Table == "" {
+		m.c.Table = defaultTable
+	}
+	if m.c.LockTimeout <= 0 {
+		m.c.LockTimeout = defaultLockTimeout
+	}
+	m.logger = m.c.Logger
+	if m.logger == nil {
+		m.logger = logger.Named("migrate")
+	}
+	m.migrations = append(m.migrations, migrations...)
+	sort.Slice(m.migrations, func(i, j int) bool {
+		return m.migrations[i].Version < m.migrations[j].Version
+	})
+	for i, mig := range m.migrations {
+		if mig.Version <= 0 {
+			return nil, fmt.Errorf("migrate: invalid version %d of %s", mig.Version, mig.Name)
+		}
+		if mig.Up == nil {
+			return nil, fmt.Errorf("migrate: %d %s has no up", mig.Version, mig.Name)
+		}
+		if i > 0 && m.migrations[i-1].Version == mig.Version {
+			return nil, fmt.Errorf("migrate: duplicate version %d", mig.Version)
+		}
+	}
+	return m, nil
+}
+
+// Up apply the pending migrations
+func (m *Migrator) Up(ctx context.Context) error {
+	if len(m.migrations) == 0 {
+		return nil
+	}
+	return m.To(ctx, m.migrations[len(m.migrations)-1].Version)
+}
+
+// Down revert the last applied migration
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.migrate(ctx, func(applied map[int64]schemaMigration) []step {
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			if _, ok := applied[m.migrations[i].Version]; ok {
+				return []step{{m: m.migrations[i]}}
+			}
+		}
+		return nil
+	})
+}
+
+// To apply the pending migrations up to version and revert the applied ones after it, 0 reverts all
+func (m *Migrator) To(ctx context.Context, version int64) error {
+	return m.migrate(ctx, func(applied map[int64]schemaMigration) []step {
+		var steps []step
+		for _, mig := range m.migrations {
+			if _, ok := applied[mig.Version]; !ok && mig.Version <= version {
+				steps = append(steps, step{m: mig, up: true})
+			}
+		}
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			mig := m.migrations[i]
+			if _, ok := applied[mig.Version]; ok && mig.Version > version {
+				steps = append(steps, step{m: mig})
+			}
+		}
+		return steps
+	})
+}
+
+// Status list the known and the applied migrations by version
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(m.session(ctx))
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Status, 0, len(m.migrations))
+	known := make(map[int64]bool, len(m.migrations))
+	for _, mig := range m.migrations {
+		known[mig.Version] = true
+		a, ok := applied[mig.Version]
+		list = append(list, Status{Version: mig.Version, Name: mig.Name, Applied: ok, AppliedAt: a.AppliedAt})
+	}
+	for v, a := range applied {
+		if !known[v] {
+			list = append(list, Status{Version: v, Name: a.Name, Applied: true, AppliedAt: a.AppliedAt, Missing: true})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Version < list[j].Version
+	})
+	return list, nil
+}
+
+type step struct {
+	m  *Migration
+	up bool
+}
+
+func (s step) direction() string {
+	if s.up {
+		return "up"
+	}
+	return "down"
+}
+
+// migrate run the steps planned from the applied versions while holding the lock
+func (m *Migrator) migrate(ctx context.Context, plan func(applied map[int64]schemaMigration) []step) error {
+	if m.c.DryRun {
+		applied, err := m.applied(m.session(ctx))
+		if err != nil {
+			return err
+		}
+		for _, s := range plan(applied) {
+			m.logger.Info("migrate dry run", zap.String("direction", s.direction()),
+				zap.Int64("version", s.m.Version), zap.String("name", s.m.Name))
+		}
+		return nil
+	}
+	// several instances starting together race on the creation of the table
+	db, unlock, err := m.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := m.createTable(db); err != nil {
+		return err
+	}
+
+	applied, err := m.applied(db)
+	if err != nil {
+		return err
+	}
+	for _, s := range plan(applied) {
+		if err := m.run(db, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) run(db *gorm.DB, s step) error {
+	if !s.up && s.m.Down == nil {
+		return fmt.Errorf("migrate: %d %s has no down", s.m.Version, s.m.Name)
+	}
+	start := time.Now()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if !s.up {
+			if err := s.m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Table(m.c.Table).Where("version = ?", s.m.Version).Delete(&schemaMigration{}).Error
+		}
+		if err := s.m.Up(tx); err != nil {
+			return err
+		}
+		return tx.Table(m.c.Table).Create(&schemaMigration{
+			Version:   s.m.Version,
+			Name:      s.m.Name,
+			AppliedAt: time.Now(),
+		}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("migrate: %s %d %s: %w", s.direction(), s.m.Version, s.m.Name, err)
+	}
+	m.logger.Info("migrate", zap.String("direction", s.direction()),
+		zap.Int64("version", s.m.Version), zap.String("name", s.m.Name),
+		zap.Duration("elapsed", time.Since(start)))
+	return nil
+}
+
+// session return the db bound to ctx, on the primary if db has replicas
+func (m *Migrator) session(ctx context.Context) *gorm.DB {
+	return m.db.WithContext(dbgo.WithPrimary(ctx))
+}
+
+func (m *Migrator) createTable(db *gorm.DB) error {
+	if err := db.Table(m.c.Table).AutoMigrate(&schemaMigration{}); err != nil {
+		return fmt.Errorf("migrate: create %s: %w", m.c.Table, err)
+	}
+	return nil
+}
+
+// applied return the applied migrations by version, none if the table does not exist
+func (m *Migrator) applied(db *gorm.DB) (map[int64]schemaMigration, error) {
+	applied := make(map[int64]schemaMigration)
+	if !db.Migrator().HasTable(m.c.Table) {
+		return applied, nil
+	}
+	var rows []schemaMigration
+	if err := db.Table(m.c.Table).Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("migrate: read %s: %w", m.c.Table, err)
+	}
+	for _, r := range rows {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
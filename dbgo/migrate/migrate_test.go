@@ -0,0 +1,263 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/happyxhw/gopkg/dbgo"
+	"github.com/happyxhw/gopkg/logger/loggertest"
+	"github.com/mattn/go-sqlite3"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gLogger "gorm.io/gorm/logger"
+)
+
+func writeMigrations(t *testing.T, files map[string]string) http.FileSystem {
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return http.Dir(dir)
+}
+
+func TestMigrate(t *testing.T) {
+	logs := loggertest.Observe(t)
+	ctx := context.Background()
+	db, err := dbgo.NewSqliteDB(&dbgo.Config{DB: t.Name(), Memory: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbgo.Close(db)
+
+	fs := writeMigrations(t, map[string]string{
+		"0001_create_users.up.sql": `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+CREATE TABLE audit (name TEXT);
+CREATE TRIGGER users_audit AFTER INSERT ON users
+BEGIN
+	INSERT INTO audit (name) VALUES (new.name);
+END;
+-- seed
+INSERT INTO users (name) VALUES ('a;b');
+`,
+		"0001_create_users.down.sql": "DROP TABLE audit;\nDROP TABLE users;",
+		"0002_rename_name.up.sql":    "ALTER TABLE users RENAME COLUMN name TO user_name;",
+		"0002_rename_name.down.sql":  "ALTER TABLE users RENAME COLUMN user_name TO name;",
+		"README.md":                  "ignored",
+	})
+	files, err := FromFS(fs, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backfill := &Migration{
+		Version: 3,
+		Name:    "backfill",
+		Up: func(tx *gorm.DB) error {
+			return tx.Exec("UPDATE users SET user_name = upper(user_name)").Error
+		},
+	}
+	m, err := New(db, nil, append(files, backfill)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dry, _ := New(db, &Config{DryRun: true}, append(files, backfill)...)
+	if err := dry.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	logs.AssertLogged(t, zapcore.InfoLevel, "migrate dry run", zap.Int64("version", 3))
+	if db.Migrator().HasTable("users") {
+		t.Error("dry run migrated")
+	}
+
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var name string
+	db.Raw("SELECT user_name FROM users").Scan(&name)
+	if name != "A;B" {
+		t.Errorf("user_name %q", name)
+	}
+	var audited int64
+	db.Table("audit").Count(&audited)
+	if audited != 1 {
+		t.Errorf("%d audited by the trigger", audited)
+	}
+	status, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != 3 || !status[0].Applied || !status[2].Applied || status[1].Name != "rename_name" || status[2].AppliedAt.IsZero() {
+		t.Errorf("status %+v", status)
+	}
+
+	// 3 has no down
+	if err := m.To(ctx, 1); err == nil {
+		t.Error("migrated down without down")
+	}
+	backfill.Down = func(tx *gorm.DB) error { return nil }
+	if err := m.To(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec("SELECT name FROM users").Error; err != nil {
+		t.Error("rename not reverted")
+	}
+	if err := m.Down(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if db.Migrator().HasTable("users") {
+		t.Error("users not dropped")
+	}
+	status, _ = m.Status(ctx)
+	for _, s := range status {
+		if s.Applied {
+			t.Errorf("%d applied", s.Version)
+		}
+	}
+}
+
+func TestStatements(t *testing.T) {
+	fn := `CREATE FUNCTION touch() RETURNS trigger AS $$
+BEGIN
+	NEW.updated_at := now();
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER users_touch BEFORE UPDATE ON users FOR EACH ROW EXECUTE FUNCTION touch();
+`
+	if got := statements("postgres", fn); len(got) != 1 || got[0] != strings.TrimSpace(fn) {
+		t.Errorf("postgres %q", got)
+	}
+
+	trigger := `CREATE TABLE audit (name TEXT);
+DELIMITER //
+CREATE TRIGGER users_audit AFTER INSERT ON users FOR EACH ROW
+BEGIN
+	INSERT INTO audit (name) VALUES (NEW.name);
+END//
+DELIMITER ;
+INSERT INTO users (name) VALUES ('a');
+-- end
+`
+	got := statements("mysql", trigger)
+	if len(got) != 3 || !strings.HasSuffix(got[1], "END") || !strings.Contains(got[1], "VALUES (NEW.name);") {
+		t.Errorf("mysql %q", got)
+	}
+}
+
+func TestLock(t *testing.T) {
+	ctx := context.Background()
+	db, err := dbgo.NewSqliteDB(&dbgo.Config{DB: t.Name(), Memory: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbgo.Close(db)
+	up := &Migration{Version: 1, Name: "noop", Up: func(tx *gorm.DB) error { return nil }}
+	m, _ := New(db, &Config{LockTimeout: 200 * time.Millisecond}, up)
+	_, unlock, err := m.lock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(ctx); !errors.Is(err, ErrLocked) {
+		t.Errorf("migrated while locked: %v", err)
+	}
+	unlock()
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// a failure other than a held lock is returned at once
+	err = db.Callback().Create().Before("gorm:create").Register("test:read_only", func(tx *gorm.DB) {
+		if tx.Statement.Table == "schema_migrations_lock" {
+			_ = tx.AddError(errors.New("read-only database"))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, _, err := m.lock(ctx); err == nil || errors.Is(err, ErrLocked) || time.Since(start) > 100*time.Millisecond {
+		t.Errorf("lock failure after %s: %v", time.Since(start), err)
+	}
+	_ = db.Callback().Create().Remove("test:read_only")
+
+	if _, err := New(db, nil, up, up); err == nil {
+		t.Error("duplicate version accepted")
+	}
+}
+
+// advisoryLocks the postgres advisory locks of the advisory driver
+var advisoryLocks sync.Map
+
+func init() {
+	sql.Register("sqlite3_advisory", &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			err := conn.RegisterFunc("pg_try_advisory_lock", func(key int64) bool {
+				_, held := advisoryLocks.LoadOrStore(key, true)
+				return !held
+			}, false)
+			if err != nil {
+				return err
+			}
+			return conn.RegisterFunc("pg_advisory_unlock", func(key int64) bool {
+				_, held := advisoryLocks.Load(key)
+				advisoryLocks.Delete(key)
+				return held
+			}, false)
+		},
+	})
+}
+
+// pgDialector run the postgres code paths on sqlite
+type pgDialector struct {
+	gorm.Dialector
+}
+
+func (pgDialector) Name() string {
+	return "postgres"
+}
+
+func TestAdvisoryLock(t *testing.T) {
+	d := pgDialector{sqlite.Dialector{DriverName: "sqlite3_advisory", DSN: "file::memory:"}}
+	db, err := gorm.Open(d, &gorm.Config{Logger: gLogger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	// the migrations run on the connection holding the lock
+	sqlDB.SetMaxOpenConns(1)
+
+	up := &Migration{Version: 1, Name: "create", Up: func(tx *gorm.DB) error {
+		return tx.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY)").Error
+	}}
+	m, _ := New(db, nil, up)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !db.Migrator().HasTable("items") {
+		t.Error("migration not applied")
+	}
+	advisoryLocks.Range(func(key, _ interface{}) bool {
+		t.Errorf("lock %v not released", key)
+		return true
+	})
+}
@@ -0,0 +1,140 @@
+package migrate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// fileRe {version}_{name}.up.sql or {version}_{name}.down.sql, e.g. 0001_create_users.up.sql
+var fileRe = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// FromFS read the sql migrations of dir, named {version}_{name}.up.sql and {version}_{name}.down.sql,
+// e.g. 0001_create_users.up.sql, fs is http.Dir("migrations") or http.FS of an embed.FS.
+// The statements of a file are separated by a ; at the end of a line for mysql, see splitStatements,
+// postgres and sqlite run the whole file at once.
+func FromFS(fs http.FileSystem, dir string) ([]*Migration, error) {
+	d, err := fs.Open(dir)
+	if err != nil {
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+	defer d.Close()
+	infos, err := d.Readdir(-1)
+	if err != nil {
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+	byVersion := make(map[int64]*Migration)
+	var list []*Migration
+	for _, info := range infos {
+		m := fileRe.FindStringSubmatch(info.Name())
+		if info.IsDir() || m == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migrate: %s: %w", info.Name(), err)
+		}
+		sql, err := readStatements(fs, path.Join(dir, info.Name()))
+		if err != nil {
+			return nil, err
+		}
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+			list = append(list, mig)
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migrate: version %d named %s and %s", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = execFunc(sql)
+		} else {
+			mig.Down = execFunc(sql)
+		}
+	}
+	return list, nil
+}
+
+func readStatements(fs http.FileSystem, name string) (string, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return "", fmt.Errorf("migrate: %w", err)
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return "", fmt.Errorf("migrate: %s: %w", name, err)
+	}
+	return string(b), nil
+}
+
+// statements the statements of a sql file to run on dialect, only mysql needs them split,
+// postgres and sqlite run the whole file, e.g. with $$ function bodies or BEGIN ... END triggers
+func statements(dialect, sql string) []string {
+	if dialect == "mysql" {
+		return splitStatements(sql)
+	}
+	if s := strings.TrimSpace(sql); s != "" && !onlyComments(s) {
+		return []string{s}
+	}
+	return nil
+}
+
+// splitStatements split sql on the ; ending a line, the mysql driver runs a single statement at a time.
+// DELIMITER // changes the delimiter until DELIMITER ;, e.g. around a trigger body, like the mysql client
+func splitStatements(sql string) []string {
+	var statements []string
+	var cur strings.Builder
+	delimiter := ";"
+	for _, line := range strings.Split(sql, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if fields := strings.Fields(trimmed); len(fields) == 2 && strings.EqualFold(fields[0], "DELIMITER") {
+			delimiter = fields[1]
+			continue
+		}
+		if !strings.HasSuffix(trimmed, delimiter) {
+			cur.WriteString(line)
+			cur.WriteString("\n")
+			continue
+		}
+		if delimiter == ";" {
+			cur.WriteString(line)
+		} else {
+			cur.WriteString(strings.TrimSuffix(trimmed, delimiter))
+		}
+		if s := strings.TrimSpace(cur.String()); s != ";" && s != "" {
+			statements = append(statements, s)
+		}
+		cur.Reset()
+	}
+	if s := strings.TrimSpace(cur.String()); s != "" && !onlyComments(s) {
+		statements = append(statements, s)
+	}
+	return statements
+}
+
+func onlyComments(sql string) bool {
+	for _, line := range strings.Split(sql, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "--") {
+			return false
+		}
+	}
+	return true
+}
+
+func execFunc(sql string) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		for _, s := range statements(tx.Dialector.Name(), sql) {
+			if err := tx.Exec(s).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,341 @@
+package dbgo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	gLogger "gorm.io/gorm/logger"
+)
+
+// replica selection policies
+const (
+	RandomPolicy       = "random"
+	RoundRobinPolicy   = "round_robin"
+	LeastLatencyPolicy = "least_latency"
+)
+
+const (
+	resolverName               = "dbgo:resolver"
+	restoreName                = "dbgo:resolver_restore"
+	defaultHealthCheckInterval = 10 * time.Second
+)
+
+type primaryKey struct{}
+
+// WithPrimary return a context sending the queries run with it to the primary, e.g. to read your writes:
+//
+//	db.WithContext(dbgo.WithPrimary(ctx)).First(&user)
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+func usePrimary(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	v, _ := ctx.Value(primaryKey{}).(bool)
+	return v
+}
+
+// ReplicaStatus state of a replica
+type ReplicaStatus struct {
+	// Name host:port/db of the replica
+	Name    string
+	Healthy bool
+	// Latency of the last health check
+	Latency time.Duration
+}
+
+// Replicas return the status of the replicas of db, nil if it has none
+func Replicas(db *gorm.DB) []ReplicaStatus {
+	r, ok := db.Config.Plugins[resolverName].(*resolver)
+	if !ok {
+		return nil
+	}
+	status := make([]ReplicaStatus, 0, len(r.replicas))
+	for _, rep := range r.replicas {
+		status = append(status, ReplicaStatus{
+			Name:    rep.name,
+			Healthy: rep.isHealthy(),
+			Latency: time.Duration(atomic.LoadInt64(&rep.latency)),
+		})
+	}
+	return status
+}
+
+type replica struct {
+	name    string
+	db      *sql.DB
+	stats   *statsCollector
+	healthy int32
+	// latency nanoseconds
+	latency int64
+}
+
+func (r *replica) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+// resolver gorm plugin routing the reads to the replicas
+type resolver struct {
+	primary  gorm.ConnPool
+	replicas []*replica
+	policy   func([]*replica) *replica
+	logger   *zap.Logger
+	interval time.Duration
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	doneCh   chan struct{}
+}
+
+// newResolver open the replicas of dbConfig, the unreachable ones are ejected by the first check
+func newResolver(dbConfig *Config, dbType DBType, l gLogger.Interface) (*resolver, error) {
+	r := &resolver{
+		logger:   dbLogger(dbConfig),
+		interval: dbConfig.HealthCheckInterval,
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+	if r.interval <= 0 {
+		r.interval = defaultHealthCheckInterval
+	}
+	switch dbConfig.Policy {
+	case RoundRobinPolicy:
+		var next uint32
+		r.policy = func(replicas []*replica) *replica {
+			return replicas[int(atomic.AddUint32(&next, 1)-1)%len(replicas)]
+		}
+	case LeastLatencyPolicy:
+		r.policy = leastLatency
+	default:
+		r.policy = func(replicas []*replica) *replica {
+			return replicas[rand.Intn(len(replicas))]
+		}
+	}
+	for _, rc := range dbConfig.Replicas {
+		c := replicaConfig(dbConfig, rc)
+		d, err := dialector(c, dbType)
+		if err != nil {
+			r.close()
+			return nil, err
+		}
+		db, err := gorm.Open(d, &gorm.Config{Logger: l, DisableAutomaticPing: true})
+		if err != nil {
+			r.close()
+			return nil, err
+		}
+		sqlDB, err := db.DB()
+		if err != nil {
+			r.close()
+			return nil, err
+		}
+		setPool(sqlDB, c)
+		r.replicas = append(r.replicas, &replica{
+			name:    replicaName(c),
+			db:      sqlDB,
+			stats:   registerStats(dbLogger(dbConfig), dbConfig.DB, poolAddr(c, dbType), replicaName(c), sqlDB),
+			healthy: 1,
+		})
+	}
+	return r, nil
+}
+
+// replicaConfig the primary config with the fields set by rc
+func replicaConfig(primary *Config, rc ReplicaConfig) *Config {
+	c := *primary
+	c.Replicas = nil
+	if rc.Host != "" {
+		c.Host = rc.Host
+	}
+	if rc.Port != 0 {
+		c.Port = rc.Port
+	}
+	if rc.User != "" {
+		c.User = rc.User
+	}
+	if rc.Password != "" {
+		c.Password = rc.Password
+	}
+	if rc.DB != "" {
+		c.DB = rc.DB
+	}
+	return &c
+}
+
+func replicaName(c *Config) string {
+	if c.Memory {
+		return c.DB
+	}
+	return fmt.Sprintf("%s:%d/%s", c.Host, c.Port, c.DB)
+}
+
+func leastLatency(replicas []*replica) *replica {
+	best := replicas[0]
+	for _, r := range replicas[1:] {
+		if atomic.LoadInt64(&r.latency) < atomic.LoadInt64(&best.latency) {
+			best = r
+		}
+	}
+	return best
+}
+
+func (r *resolver) Name() string {
+	return resolverName
+}
+
+func (r *resolver) Initialize(db *gorm.DB) error {
+	r.primary = db.Config.ConnPool
+	r.check()
+	go r.run()
+
+	cb := db.Callback()
+	if err := cb.Create().Before("*").Register(resolverName, r.usePrimary); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("*").Register(resolverName, r.usePrimary); err != nil {
+		return err
+	}
+	if err := cb.Delete().Before("*").Register(resolverName, r.usePrimary); err != nil {
+		return err
+	}
+	if err := cb.Query().Before("*").Register(resolverName, r.useReplica); err != nil {
+		return err
+	}
+	if err := cb.Row().Before("*").Register(resolverName, r.useReplica); err != nil {
+		return err
+	}
+	if err := cb.Raw().Before("*").Register(resolverName, r.useReplica); err != nil {
+		return err
+	}
+	if err := cb.Query().After("*").Register(restoreName, r.restorePrimary); err != nil {
+		return err
+	}
+	if err := cb.Row().After("*").Register(restoreName, r.restorePrimary); err != nil {
+		return err
+	}
+	return cb.Raw().After("*").Register(restoreName, r.restorePrimary)
+}
+
+func (r *resolver) usePrimary(db *gorm.DB) {
+	if !isPinned(db.Statement.ConnPool) {
+		db.Statement.ConnPool = r.primary
+	}
+}
+
+// useReplica send the statement to a replica unless it runs in a transaction or on a dedicated connection,
+// locks rows, is not a select or its context asks for the primary
+func (r *resolver) useReplica(db *gorm.DB) {
+	stmt := db.Statement
+	if isPinned(stmt.ConnPool) {
+		return
+	}
+	_, locking := stmt.Clauses["FOR"]
+	if locking || usePrimary(stmt.Context) || !isRead(stmt.SQL.String()) {
+		stmt.ConnPool = r.primary
+		return
+	}
+	if rep := r.pick(); rep != nil {
+		stmt.ConnPool = rep.db
+	} else {
+		stmt.ConnPool = r.primary
+	}
+}
+
+// restorePrimary put the primary back once the statement ran,
+// a chained statement reused for a transaction or a write must not keep the replica
+func (r *resolver) restorePrimary(db *gorm.DB) {
+	if !isPinned(db.Statement.ConnPool) {
+		db.Statement.ConnPool = r.primary
+	}
+}
+
+// isRead report whether the raw sql is a select without lock, an empty sql is built by gorm from a query
+func isRead(sql string) bool {
+	sql = strings.TrimSpace(sql)
+	if sql == "" {
+		return true
+	}
+	lower := strings.ToLower(sql)
+	return strings.HasPrefix(lower, "select") &&
+		!strings.HasSuffix(lower, "for update") && !strings.HasSuffix(lower, "for share")
+}
+
+// pick return a healthy replica, nil if none
+func (r *resolver) pick() *replica {
+	healthy := make([]*replica, 0, len(r.replicas))
+	for _, rep := range r.replicas {
+		if rep.isHealthy() {
+			healthy = append(healthy, rep)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+	return r.policy(healthy)
+}
+
+func (r *resolver) run() {
+	defer close(r.doneCh)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.check()
+		case <-r.stopCh:
+			return
+		}
+	}
+}
+
+// check ping the replicas, eject the failing ones and restore the recovered ones
+func (r *resolver) check() {
+	for _, rep := range r.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), pingTimeout(r.interval))
+		start := time.Now()
+		err := rep.db.PingContext(ctx)
+		cancel()
+		if err != nil {
+			if atomic.SwapInt32(&rep.healthy, 0) == 1 {
+				r.logger.Warn("replica ejected", zap.String("replica", rep.name), zap.Error(err))
+			}
+			continue
+		}
+		atomic.StoreInt64(&rep.latency, int64(time.Since(start)))
+		if atomic.SwapInt32(&rep.healthy, 1) == 0 {
+			r.logger.Info("replica available", zap.String("replica", rep.name))
+		}
+	}
+}
+
+func (r *resolver) close() {
+	r.stopOnce.Do(func() {
+		close(r.stopCh)
+		if r.primary != nil {
+			<-r.doneCh
+		}
+		for _, rep := range r.replicas {
+			rep.stats.unregister()
+			_ = rep.db.Close()
+		}
+	})
+}
+
+func isTransaction(connPool gorm.ConnPool) bool {
+	_, ok := connPool.(gorm.TxCommitter)
+	return ok
+}
+
+// isPinned report whether connPool is a transaction or a dedicated connection, e.g. of the migration lock
+func isPinned(connPool gorm.ConnPool) bool {
+	_, ok := connPool.(*sql.Conn)
+	return ok || isTransaction(connPool)
+}
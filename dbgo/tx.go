@@ -0,0 +1,137 @@
+package dbgo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/happyxhw/gopkg/utils"
+	"github.com/jackc/pgconn"
+	"gorm.io/gorm"
+)
+
+const (
+	txStateKey = "dbgo:tx"
+
+	defaultTxRetries    = 3
+	defaultTxMinBackoff = 10 * time.Millisecond
+	defaultTxMaxBackoff = time.Second
+)
+
+// TxOptions options of WithTx
+type TxOptions struct {
+	// Isolation isolation level, default the one of the database
+	Isolation sql.IsolationLevel
+	ReadOnly  bool
+	// MaxRetries retries of a deadlock, lock wait timeout or serialization failure, default 3, -1 disables them
+	MaxRetries int
+	// MinBackoff and MaxBackoff bounds of the utils.RetryBackoff between the retries, default 10ms and 1s
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// ErrExternalTx WithTx called with a transaction not started by WithTx, e.g. by db.Transaction or db.Begin,
+// its commit is unknown to the AfterCommit hooks and a retry would run a savepoint of a rolled back transaction
+var ErrExternalTx = errors.New("dbgo: WithTx in a transaction not started by WithTx")
+
+// txState hooks of a transaction
+type txState struct {
+	hooks []func()
+}
+
+// WithTx run fn in a transaction of db, committed if fn returns nil, rolled back else.
+// A deadlock, a lock wait timeout or a serialization failure retries the whole transaction,
+// fn must then be safe to run again.
+// Called with a tx of another WithTx, fn runs in a savepoint of it, rolled back if fn fails, opts are ignored,
+// called with another transaction, it returns ErrExternalTx:
+//
+//	err := dbgo.WithTx(ctx, db, nil, func(tx *gorm.DB) error {
+//		if err := tx.Create(&order).Error; err != nil {
+//			return err
+//		}
+//		dbgo.AfterCommit(tx, func() { notify(order) })
+//		return dbgo.WithTx(ctx, tx, nil, func(tx *gorm.DB) error {
+//			return tx.Create(&audit).Error
+//		})
+//	})
+func WithTx(ctx context.Context, db *gorm.DB, opts *TxOptions, fn func(tx *gorm.DB) error) error {
+	if state, ok := db.Get(txStateKey); ok {
+		return savepoint(ctx, db, state.(*txState), fn)
+	}
+	if isTransaction(db.Statement.ConnPool) {
+		return ErrExternalTx
+	}
+	o := TxOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.MaxRetries == 0 {
+		o.MaxRetries = defaultTxRetries
+	}
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = defaultTxMinBackoff
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = defaultTxMaxBackoff
+	}
+	txOpts := &sql.TxOptions{Isolation: o.Isolation, ReadOnly: o.ReadOnly}
+	for retry := 0; ; retry++ {
+		state := &txState{}
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return fn(tx.Set(txStateKey, state).Session(&gorm.Session{}))
+		}, txOpts)
+		if err == nil {
+			for _, hook := range state.hooks {
+				hook()
+			}
+			return nil
+		}
+		if !IsRetryable(err) || retry >= o.MaxRetries {
+			return err
+		}
+		backoff := utils.RetryBackoff(retry, o.MinBackoff, o.MaxBackoff)
+		db.Logger.Warn(ctx, "retry transaction after %s: %v", backoff, err)
+		if err := utils.Sleep(ctx, backoff); err != nil {
+			return err
+		}
+	}
+}
+
+// savepoint run fn in a savepoint of tx, dropping the hooks it registered if it fails
+func savepoint(ctx context.Context, tx *gorm.DB, state *txState, fn func(tx *gorm.DB) error) error {
+	n := len(state.hooks)
+	err := tx.WithContext(ctx).Transaction(fn)
+	if err != nil {
+		state.hooks = state.hooks[:n]
+	}
+	return err
+}
+
+// AfterCommit run hook once the transaction of tx, started by WithTx, is committed,
+// it is dropped if the transaction or its savepoint rolls back.
+// Without transaction hook runs at once.
+func AfterCommit(tx *gorm.DB, hook func()) {
+	state, ok := tx.Get(txStateKey)
+	if !ok {
+		hook()
+		return
+	}
+	s := state.(*txState)
+	s.hooks = append(s.hooks, hook)
+}
+
+// IsRetryable report whether err is a deadlock or a lock wait timeout of mysql (1213, 1205),
+// or a serialization failure or a deadlock of postgres (40001, 40P01)
+func IsRetryable(err error) bool {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		return myErr.Number == 1213 || myErr.Number == 1205
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "40001" || pgErr.Code == "40P01"
+	}
+	return false
+}
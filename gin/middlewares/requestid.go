@@ -1,12 +1,16 @@
 package middlewares
 
 import (
+	"fmt"
+
 	"github.com/gin-gonic/gin"
 	"github.com/gofrs/uuid"
+	"github.com/happyxhw/gopkg/logger"
 )
 
 const key = "X-Request-Id"
 
+// RequestId set the request id and the incoming trace info to gin.Context and the request context
 func RequestId() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Check for incoming header, use it if exists
@@ -20,6 +24,12 @@ func RequestId() gin.HandlerFunc {
 
 		// Expose it for use in the application
 		c.Set(key, requestID)
+		// the user header of a client is not trusted, see ContextUser
+		c.Request = c.Request.WithContext(logger.NewContext(c.Request.Context(), logger.ContextInfo{
+			RequestID: requestID,
+			TraceID:   c.Request.Header.Get(logger.TraceIDKey),
+			SpanID:    c.Request.Header.Get(logger.SpanIDKey),
+		}))
 
 		// Set X-Request-Id header
 		c.Writer.Header().Set("X-Request-Id", requestID)
@@ -30,3 +40,16 @@ func RequestId() gin.HandlerFunc {
 func GetReqID(c *gin.Context) string {
 	return c.GetString(key)
 }
+
+// ContextUser copy the identity set by the jwt middleware to the request context,
+// must be used after the jwt MiddlewareFunc
+func ContextUser(identityKey string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if v, ok := c.Get(identityKey); ok {
+			if user := fmt.Sprint(v); user != "" {
+				c.Request = c.Request.WithContext(logger.WithUser(c.Request.Context(), user))
+			}
+		}
+		c.Next()
+	}
+}
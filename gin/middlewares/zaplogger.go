@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	gopkgLogger "github.com/happyxhw/gopkg/logger"
 	"go.uber.org/zap"
 )
 
@@ -11,23 +12,26 @@ import (
 //
 // Requests with errors are logged using zap.Error().
 // Requests without errors are logged using zap.Info().
+// Sensitive query params are masked by logger.DefaultRedactor.
 func GinZap(logger *zap.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
 		// some evil middlewares modify this values
 		path := c.Request.URL.Path
-		query := c.Request.URL.RawQuery
+		query := gopkgLogger.DefaultRedactor().Query(c.Request.URL.RawQuery)
 		c.Next()
 
 		latency := time.Since(start)
 		clientIP := c.ClientIP()
 		method := c.Request.Method
 		statusCode := c.Writer.Status()
+		// X-Request-Id kept for the existing log queries, request_id comes with the context fields
 		reqID := GetReqID(c)
+		l := logger.With(gopkgLogger.ContextFields(c.Request.Context())...)
 
 		switch {
 		case statusCode >= 400 && statusCode <= 499:
-			logger.Warn("[GIN]",
+			l.Warn("[GIN]",
 				zap.Int("code", statusCode),
 				zap.String("latency", latency.String()),
 				zap.String("ip", clientIP),
@@ -40,7 +44,7 @@ func GinZap(logger *zap.Logger) gin.HandlerFunc {
 			)
 			c.JSON(statusCode, gin.H{"code": statusCode, "msg": c.Errors.String()})
 		case statusCode >= 500:
-			logger.Error("[GIN]",
+			l.Error("[GIN]",
 				zap.Int("code", statusCode),
 				zap.String("latency", latency.String()),
 				zap.String("ip", clientIP),
@@ -53,7 +57,7 @@ func GinZap(logger *zap.Logger) gin.HandlerFunc {
 			)
 			c.JSON(statusCode, gin.H{"code": statusCode, "msg": c.Errors.String()})
 		default:
-			logger.Info("[GIN]",
+			l.Info("[GIN]",
 				zap.Int("code", statusCode),
 				zap.String("latency", latency.String()),
 				zap.String("ip", clientIP),
@@ -16,8 +16,11 @@ import (
 )
 
 type Config struct {
-	Addr string
-	Mode string
+	Addr string `default:":8080" desc:"listen address"`
+	Mode string `default:"release" validate:"oneof=debug release test" desc:"gin mode, debug, release or test"`
+	// LogLevel mount the unauthenticated dev/log/level handler, only for a server out of reach of the clients,
+	// else mount RegisterLogLevel behind an authentication
+	LogLevel bool `mapstructure:"log_level" desc:"mount the dev/log/level handler"`
 }
 
 func Serve(router *gin.Engine, c *Config) {
@@ -26,6 +29,9 @@ func Serve(router *gin.Engine, c *Config) {
 	// init router
 	gin.SetMode(c.Mode)
 	pprof.Register(router, "dev/pprof")
+	if c.LogLevel {
+		RegisterLogLevel(router, "dev/log/level")
+	}
 
 	server := &http.Server{
 		Addr:           c.Addr,
@@ -56,3 +62,10 @@ func Serve(router *gin.Engine, c *Config) {
 	}
 	logger.Info("server exited")
 }
+
+// RegisterLogLevel mount the default logger level handler, GET to read and PUT to change
+func RegisterLogLevel(router *gin.Engine, path string) {
+	h := gin.WrapH(logger.DefaultLevelHandler())
+	router.GET(path, h)
+	router.PUT(path, h)
+}
@@ -1,9 +1,13 @@
 package gin
 
 import (
+	"bytes"
+	"encoding/json"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/gin-gonic/gin"
 	"github.com/happyxhw/gopkg/dbgo"
 	"github.com/happyxhw/gopkg/gin/api/v1/user"
@@ -14,7 +18,8 @@ import (
 	"go.uber.org/zap"
 )
 
-func TestServe(t *testing.T) {
+func newTestEngine(t *testing.T) *gin.Engine {
+	gin.SetMode(gin.TestMode)
 	r := NewEngine(logger.GetLogger().WithOptions(zap.AddCallerSkip(-1)))
 	r.GET("/api/v1/greeter", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
@@ -22,26 +27,31 @@ func TestServe(t *testing.T) {
 			"msg":  "reply",
 		})
 	})
-	c := Config{
-		Addr: "0.0.0.0:8080",
-		Mode: "debug",
-	}
 
-	db, _ := dbgo.NewPostgresDb(&dbgo.Config{
-		User:         "happyxhw",
-		Password:     "808258XXxx",
-		Host:         "127.0.0.1",
-		Port:         5432,
-		DB:           "stravadb",
+	db, err := dbgo.NewSqliteDB(&dbgo.Config{
+		DB:           t.Name(),
+		Memory:       true,
 		MaxIdleConns: 1,
 		MaxOpenConns: 1,
-		Logger:       logger.GetLogger().WithOptions(zap.AddCallerSkip(2)),
 		Level:        "info",
 	})
-	red, _ := goredis.NewRedis(&goredis.Config{
-		Host: "127.0.0.1:6379",
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mr.Close)
+	red, err := goredis.NewRedis(&goredis.Config{
+		Host: mr.Addr(),
 	})
-	_ = db.AutoMigrate(&models.BaseUser{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.BaseUser{}); err != nil {
+		t.Fatal(err)
+	}
 	key, identityKey := "test_key", "email"
 	userHandler := user.NewUser(db, red, identityKey)
 	jwtHandler := middlewares.NewJwt(key, identityKey, userHandler)
@@ -52,6 +62,46 @@ func TestServe(t *testing.T) {
 	v1.GET("/auth/refresh", jwtHandler.RefreshHandler)
 	v1.POST("/auth/request-pass", userHandler.RequestPass)
 	v1.POST("/auth/reset-pass", userHandler.ResetPass)
+	return r
+}
 
-	Serve(r, &c)
+func post(r http.Handler, path string, body interface{}) *httptest.ResponseRecorder {
+	b, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestServe(t *testing.T) {
+	r := newTestEngine(t)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/greeter", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("greeter %d", w.Code)
+	}
+
+	u := map[string]string{"email": "a@b.c", "password": "secret"}
+	if w := post(r, "/api/v1/auth/register", u); w.Code != http.StatusCreated {
+		t.Fatalf("register %d %s", w.Code, w.Body)
+	}
+	if w := post(r, "/api/v1/auth/register", u); w.Code != http.StatusBadRequest {
+		t.Errorf("register twice %d", w.Code)
+	}
+	w = post(r, "/api/v1/auth/login", u)
+	var token struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &token); w.Code != http.StatusOK || err != nil || token.Token == "" {
+		t.Errorf("login %d %s", w.Code, w.Body)
+	}
+	u["password"] = "wrong"
+	if w := post(r, "/api/v1/auth/login", u); w.Code != http.StatusUnauthorized {
+		t.Errorf("login with a wrong password %d", w.Code)
+	}
+	if w := post(r, "/api/v1/auth/request-pass", map[string]string{"email": "x@y.z"}); w.Code != http.StatusBadRequest {
+		t.Errorf("request pass of an unknown user %d", w.Code)
+	}
 }
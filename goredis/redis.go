@@ -7,11 +7,11 @@ import (
 // RedisConn redis client
 
 type Config struct {
-	Host         string
-	Password     string
-	Db           int
-	PoolSize     int `mapstructure:"pool_size"`
-	MinIdleConns int `mapstructure:"min_idle_conns"`
+	Host         string `default:"127.0.0.1:6379" desc:"redis address"`
+	Password     string `desc:"redis password"`
+	Db           int    `validate:"min=0,max=15" desc:"redis database"`
+	PoolSize     int    `mapstructure:"pool_size" validate:"min=0" desc:"connection pool size"`
+	MinIdleConns int    `mapstructure:"min_idle_conns" validate:"min=0" desc:"min idle connections"`
 }
 
 // NewRedis Initialize the Redis instance
@@ -3,19 +3,38 @@ package grpc
 import (
 	"time"
 
+	grpcMiddleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpcRetry "github.com/grpc-ecosystem/go-grpc-middleware/retry"
 	"github.com/spf13/viper"
 	"google.golang.org/grpc"
 )
 
+// ClientConfig grpc client config
+type ClientConfig struct {
+	Addr     string `validate:"required"`
+	MaxRetry uint   `mapstructure:"max_retry" default:"3"`
+}
+
+// Client dial the address of the grpc.greeter key
 func Client(maxRetry uint) (*grpc.ClientConn, error) {
+	return NewClient(&ClientConfig{Addr: viper.GetString("grpc.greeter"), MaxRetry: maxRetry})
+}
+
+// NewClient dial c.Addr with the context and retry interceptors
+func NewClient(c *ClientConfig) (*grpc.ClientConn, error) {
 	opts := []grpcRetry.CallOption{
 		grpcRetry.WithBackoff(grpcRetry.BackoffLinear(100 * time.Millisecond)),
-		grpcRetry.WithMax(maxRetry),
+		grpcRetry.WithMax(c.MaxRetry),
 	}
-	conn, err := grpc.Dial(viper.GetString("grpc.greeter"),
-		grpc.WithStreamInterceptor(grpcRetry.StreamClientInterceptor(opts...)),
-		grpc.WithUnaryInterceptor(grpcRetry.UnaryClientInterceptor(opts...)),
+	conn, err := grpc.Dial(c.Addr,
+		grpc.WithStreamInterceptor(grpcMiddleware.ChainStreamClient(
+			StreamClientContextInterceptor(),
+			grpcRetry.StreamClientInterceptor(opts...),
+		)),
+		grpc.WithUnaryInterceptor(grpcMiddleware.ChainUnaryClient(
+			UnaryClientContextInterceptor(),
+			grpcRetry.UnaryClientInterceptor(opts...),
+		)),
 		grpc.WithInsecure(),
 	)
 	return conn, err
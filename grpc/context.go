@@ -0,0 +1,75 @@
+package grpc
+
+import (
+	"context"
+
+	grpcMiddleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	gopkgLogger "github.com/happyxhw/gopkg/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// ContextFromMetadata return a copy of ctx carrying the request and trace ids of the incoming metadata, see logger.Extract
+func ContextFromMetadata(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return gopkgLogger.Extract(ctx, func(key string) string {
+		if v := md.Get(key); len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	})
+}
+
+// ContextToMetadata return a copy of ctx with the logger.ContextInfo appended to the outgoing metadata
+func ContextToMetadata(ctx context.Context) context.Context {
+	var kv []string
+	gopkgLogger.Inject(ctx, func(key, value string) {
+		kv = append(kv, key, value)
+	})
+	if len(kv) == 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
+
+// UnaryServerContextInterceptor extract the logger.ContextInfo from metadata,
+// must be chained after the zap interceptor to add the fields to its logger
+func UnaryServerContextInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx = ContextFromMetadata(ctx)
+		ctxzap.AddFields(ctx, gopkgLogger.ContextFields(ctx)...)
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerContextInterceptor stream version of UnaryServerContextInterceptor
+func StreamServerContextInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := ContextFromMetadata(stream.Context())
+		ctxzap.AddFields(ctx, gopkgLogger.ContextFields(ctx)...)
+		wrapped := grpcMiddleware.WrapServerStream(stream)
+		wrapped.WrappedContext = ctx
+		return handler(srv, wrapped)
+	}
+}
+
+// UnaryClientContextInterceptor send the logger.ContextInfo of ctx as metadata
+func UnaryClientContextInterceptor() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context, method string, req, reply interface{},
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption,
+	) error {
+		return invoker(ContextToMetadata(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientContextInterceptor stream version of UnaryClientContextInterceptor
+func StreamClientContextInterceptor() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn,
+		method string, streamer grpc.Streamer, opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		return streamer(ContextToMetadata(ctx), desc, cc, method, opts...)
+	}
+}
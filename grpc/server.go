@@ -3,6 +3,7 @@ package grpc
 import (
 	"net/http"
 
+	gopkgLogger "github.com/happyxhw/gopkg/logger"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
@@ -13,15 +14,36 @@ import (
 	grpcPrometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 )
 
-func Server(logger *zap.Logger, metricsAddr string) *grpc.Server {
+// ServerOptions options of Server
+type ServerOptions struct {
+	// LogLevel mount the unauthenticated /log/level handler on the metrics port
+	LogLevel bool
+}
+
+type ServerOption func(*ServerOptions)
+
+// WithLogLevel mount the default logger level handler on the metrics port, only for a port out of reach of the clients
+func WithLogLevel() ServerOption {
+	return func(opts *ServerOptions) {
+		opts.LogLevel = true
+	}
+}
+
+func Server(logger *zap.Logger, metricsAddr string, opts ...ServerOption) *grpc.Server {
+	var options ServerOptions
+	for _, o := range opts {
+		o(&options)
+	}
 	s := grpc.NewServer(
 		grpc.StreamInterceptor(grpcMiddleware.ChainStreamServer(
 			grpcZap.StreamServerInterceptor(logger),
+			StreamServerContextInterceptor(),
 			grpcRecovery.StreamServerInterceptor(),
 			grpcPrometheus.StreamServerInterceptor,
 		)),
 		grpc.UnaryInterceptor(grpcMiddleware.ChainUnaryServer(
 			grpcZap.UnaryServerInterceptor(logger),
+			UnaryServerContextInterceptor(),
 			grpcRecovery.UnaryServerInterceptor(),
 			grpcPrometheus.UnaryServerInterceptor,
 		)),
@@ -29,6 +51,9 @@ func Server(logger *zap.Logger, metricsAddr string) *grpc.Server {
 	if metricsAddr != "" {
 		grpcPrometheus.Register(s)
 		http.Handle("/metrics", promhttp.Handler())
+		if options.LogLevel {
+			http.Handle("/log/level", gopkgLogger.DefaultLevelHandler())
+		}
 		go func() {
 			err := http.ListenAndServe(metricsAddr, nil)
 			if err != nil {
@@ -0,0 +1,277 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+var errorOutput = zapcore.Lock(os.Stderr)
+
+const (
+	defaultQueueSize     = 8192
+	defaultFlushInterval = time.Second
+)
+
+// OverflowPolicy what to do when the async queue is full
+type OverflowPolicy int8
+
+const (
+	// OverflowBlock block the caller until there is room
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest drop the entry being written
+	OverflowDropNewest
+	// OverflowDropDebug drop debug entries once the queue is 3/4 full, block the others
+	OverflowDropDebug
+)
+
+// AsyncConfig async writing config
+type AsyncConfig struct {
+	// QueueSize max queued entries, default 8192
+	QueueSize int `mapstructure:"queue_size"`
+	// FlushInterval interval to sync the outputs, default 1s
+	FlushInterval time.Duration `mapstructure:"flush_interval"`
+	// Overflow block, drop_newest, drop_debug, default block
+	Overflow string `validate:"omitempty,oneof=block drop_newest drop_debug"`
+}
+
+// AsyncStats state of an AsyncCore
+type AsyncStats struct {
+	Queued         int                      // number of entries waiting to be written
+	Dropped        uint64                   // number of dropped entries
+	DroppedByLevel map[zapcore.Level]uint64 // number of dropped entries by level
+}
+
+// ParseOverflowPolicy parse an overflow policy name, empty is block
+func ParseOverflowPolicy(text string) (OverflowPolicy, error) {
+	switch strings.ToLower(text) {
+	case "", "block":
+		return OverflowBlock, nil
+	case "drop_newest":
+		return OverflowDropNewest, nil
+	case "drop_debug":
+		return OverflowDropDebug, nil
+	}
+	return OverflowBlock, fmt.Errorf("logger: unknown overflow policy %q", text)
+}
+
+type asyncEntry struct {
+	core   zapcore.Core
+	entry  zapcore.Entry
+	fields []zapcore.Field
+	// done is set for a flush request
+	done chan error
+}
+
+type asyncQueue struct {
+	mu     sync.RWMutex
+	closed bool
+
+	root     zapcore.Core
+	ch       chan asyncEntry
+	policy   OverflowPolicy
+	interval time.Duration
+	dropped  [zapcore.FatalLevel - zapcore.DebugLevel + 1]uint64 // atomic
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// AsyncCore a zapcore.Core writing entries to the wrapped core in a background goroutine,
+// Sync waits until the queued entries are written.
+// Entries above error level are written synchronously as the process may exit right after.
+type AsyncCore struct {
+	core zapcore.Core
+	q    *asyncQueue
+}
+
+// NewAsyncCore wrap core to write asynchronously
+func NewAsyncCore(core zapcore.Core, c *AsyncConfig) (*AsyncCore, error) {
+	policy, err := ParseOverflowPolicy(c.Overflow)
+	if err != nil {
+		return nil, err
+	}
+	size := c.QueueSize
+	if size <= 0 {
+		size = defaultQueueSize
+	}
+	interval := c.FlushInterval
+	if interval <= 0 {
+		interval = defaultFlushInterval
+	}
+	q := &asyncQueue{
+		root:     core,
+		ch:       make(chan asyncEntry, size),
+		policy:   policy,
+		interval: interval,
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+	go q.run()
+	return &AsyncCore{core: core, q: q}, nil
+}
+
+func (c *AsyncCore) Enabled(l zapcore.Level) bool {
+	return c.core.Enabled(l)
+}
+
+func (c *AsyncCore) With(fields []zapcore.Field) zapcore.Core {
+	return &AsyncCore{core: c.core.With(fields), q: c.q}
+}
+
+func (c *AsyncCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *AsyncCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	if ent.Level > zapcore.ErrorLevel {
+		_ = c.Sync()
+		_ = writeEntry(c.core, ent, fields)
+		return c.q.root.Sync()
+	}
+	c.q.mu.RLock()
+	defer c.q.mu.RUnlock()
+	if c.q.closed {
+		return writeEntry(c.core, ent, fields)
+	}
+	e := asyncEntry{
+		core:  c.core,
+		entry: ent,
+		// the caller may reuse its slice
+		fields: append([]zapcore.Field(nil), fields...),
+	}
+	switch {
+	case c.q.policy == OverflowDropNewest:
+		select {
+		case c.q.ch <- e:
+		default:
+			c.q.drop(ent.Level)
+		}
+	case c.q.policy == OverflowDropDebug && ent.Level <= zapcore.DebugLevel && len(c.q.ch) >= cap(c.q.ch)*3/4:
+		c.q.drop(ent.Level)
+	default:
+		c.q.ch <- e
+	}
+	return nil
+}
+
+// Sync wait until the queued entries are written, then sync the wrapped core
+func (c *AsyncCore) Sync() error {
+	c.q.mu.RLock()
+	if c.q.closed {
+		c.q.mu.RUnlock()
+		return c.q.root.Sync()
+	}
+	done := make(chan error, 1)
+	c.q.ch <- asyncEntry{done: done}
+	c.q.mu.RUnlock()
+	return <-done
+}
+
+// Close flush the queue and stop the background goroutine, later entries are written synchronously
+func (c *AsyncCore) Close() error {
+	c.q.mu.Lock()
+	if c.q.closed {
+		c.q.mu.Unlock()
+		return nil
+	}
+	c.q.closed = true
+	c.q.mu.Unlock()
+	close(c.q.stopCh)
+	<-c.q.doneCh
+	return c.q.root.Sync()
+}
+
+// Stats return the queue state and the dropped counters
+func (c *AsyncCore) Stats() AsyncStats {
+	s := AsyncStats{
+		Queued:         len(c.q.ch),
+		DroppedByLevel: make(map[zapcore.Level]uint64),
+	}
+	for i := range c.q.dropped {
+		if n := atomic.LoadUint64(&c.q.dropped[i]); n > 0 {
+			s.Dropped += n
+			s.DroppedByLevel[zapcore.Level(i)+zapcore.DebugLevel] = n
+		}
+	}
+	return s
+}
+
+func (q *asyncQueue) drop(l zapcore.Level) {
+	if l < zapcore.DebugLevel || l > zapcore.FatalLevel {
+		return
+	}
+	atomic.AddUint64(&q.dropped[l-zapcore.DebugLevel], 1)
+}
+
+func (q *asyncQueue) run() {
+	defer close(q.doneCh)
+	ticker := time.NewTicker(q.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case e := <-q.ch:
+			q.handle(e)
+		case <-ticker.C:
+			_ = q.root.Sync()
+		case <-q.stopCh:
+			// no writer can enqueue once closed, drain what is left
+			for {
+				select {
+				case e := <-q.ch:
+					q.handle(e)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (q *asyncQueue) handle(e asyncEntry) {
+	if e.done != nil {
+		e.done <- q.root.Sync()
+		return
+	}
+	_ = writeEntry(e.core, e.entry, e.fields)
+}
+
+// writeEntry write through Check so that every core of a tee applies its own level,
+// the write error, reported by the CheckedEntry to its ErrorOutput, is also returned
+func writeEntry(core zapcore.Core, ent zapcore.Entry, fields []zapcore.Field) error {
+	ce := core.Check(ent, nil)
+	if ce == nil {
+		return nil
+	}
+	out := &errorCapture{}
+	ce.ErrorOutput = out
+	ce.Write(fields...)
+	if len(out.msg) == 0 {
+		return nil
+	}
+	_, _ = errorOutput.Write(out.msg)
+	return errors.New(strings.TrimSpace(string(out.msg)))
+}
+
+// errorCapture ErrorOutput of a CheckedEntry keeping its write error
+type errorCapture struct {
+	msg []byte
+}
+
+func (e *errorCapture) Write(p []byte) (int, error) {
+	e.msg = append(e.msg, p...)
+	return len(p), nil
+}
+
+func (e *errorCapture) Sync() error {
+	return nil
+}
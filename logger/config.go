@@ -0,0 +1,215 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// sink types
+const (
+	StdoutSink = "stdout"
+	StderrSink = "stderr"
+	FileSink   = "file"
+)
+
+// Config logger config, e.g.
+//
+//	level: info
+//	encoder: json
+//	caller: true
+//	sinks:
+//	  - type: stdout
+//	  - type: file
+//	    filename: app.log
+//	    max_size: 100
+//	  - type: file
+//	    filename: app_err.log
+//	    level: warn
+//	async:
+//	  queue_size: 8192
+//	  flush_interval: 1s
+//	  overflow: drop_debug
+//	sampling:
+//	  interval: 1s
+//	  first: 100
+//	  thereafter: 100
+//	redact:
+//	  keys: [password, token]
+//	  patterns: ['\d{16}']
+//	levels:
+//	  dbgo: debug
+type Config struct {
+	// Level debug, info, warn, error, can be changed at runtime
+	Level string `validate:"omitempty,oneofci=debug info warn error dpanic panic fatal" desc:"log level"`
+	// Encoder console, json
+	Encoder string `validate:"omitempty,oneofci=console json" desc:"log encoder, console or json"`
+	// Caller add caller file:line
+	Caller bool `desc:"add caller file:line"`
+	// Sinks outputs, stdout if empty
+	Sinks []SinkConfig `validate:"dive"`
+	// Async write in a background goroutine if set
+	Async *AsyncConfig
+	// Sampling sample entries by level and message if set
+	Sampling *SamplingConfig
+	// Redact mask sensitive fields if set, Init also makes it the DefaultRedactor
+	Redact *RedactConfig
+	// Levels levels of the named loggers by name, applied by Init
+	Levels map[string]string
+	// Cores extra outputs teed with the sinks, e.g. a rabbitmq.LogCore
+	Cores []zapcore.Core `mapstructure:"-"`
+}
+
+// SinkConfig output of a logger
+type SinkConfig struct {
+	// Type stdout, stderr, file, default file if Filename is set else stdout
+	Type string `validate:"omitempty,oneof=stdout stderr file"`
+	// Level threshold of the sink on top of the logger level, empty to follow the logger level only
+	Level string `validate:"omitempty,oneofci=debug info warn error dpanic panic fatal"`
+	// Encoder console, json, empty to use Config.Encoder
+	Encoder string `validate:"omitempty,oneofci=console json"`
+	// FileConfig rotation of the file sink
+	FileConfig `mapstructure:",squash"`
+}
+
+// New create a logger from c
+func New(c *Config, opts ...zap.Option) (*zap.Logger, error) {
+	return NewLevel(zap.NewAtomicLevel(), c, opts...)
+}
+
+// NewLevel create a logger from c whose level can be changed at runtime through level,
+// level is set to c.Level
+func NewLevel(level zap.AtomicLevel, c *Config, opts ...zap.Option) (*zap.Logger, error) {
+	l, _, _, err := newLevel(level, c, opts...)
+	return l, err
+}
+
+// Init init default logger from c
+func Init(c *Config, opts ...zap.Option) error {
+	levels := make(map[string]zapcore.Level, len(c.Levels))
+	for name, text := range c.Levels {
+		l, err := ParseLevel(text)
+		if err != nil {
+			return err
+		}
+		levels[name] = l
+	}
+	l, base, async, err := newLevel(atomicLevel, c, opts...)
+	if err != nil {
+		return err
+	}
+	for name, lvl := range levels {
+		SetNamedLevel(name, lvl)
+	}
+	if c.Redact != nil {
+		r, _ := NewRedactor(c.Redact)
+		SetRedactor(r)
+	}
+	setDefault(l, base, async)
+	return nil
+}
+
+// newLevel return the logger, its core without the level and the async core if any
+func newLevel(level zap.AtomicLevel, c *Config, opts ...zap.Option) (*zap.Logger, zapcore.Core, *AsyncCore, error) {
+	l, err := ParseLevel(c.Level)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	sinks := c.Sinks
+	if len(sinks) == 0 {
+		sinks = []SinkConfig{{Type: StdoutSink}}
+	}
+	cores := make([]zapcore.Core, 0, len(sinks))
+	for i := range sinks {
+		core, err := newSinkCore(c, &sinks[i])
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		cores = append(cores, core)
+	}
+	core := zapcore.NewTee(append(cores, c.Cores...)...)
+	if c.Redact != nil {
+		r, err := NewRedactor(c.Redact)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		core = &RedactCore{Core: core, r: r}
+	}
+	var async *AsyncCore
+	if c.Async != nil {
+		if async, err = NewAsyncCore(core, c.Async); err != nil {
+			return nil, nil, nil, err
+		}
+		core = async
+	}
+	if c.Sampling != nil {
+		core = NewSamplingCore(core, c.Sampling)
+	}
+	if c.Caller {
+		opts = append([]zap.Option{zap.AddCaller()}, opts...)
+	}
+	level.SetLevel(l)
+	return zap.New(&levelCore{Core: core, level: level}, opts...), core, async, nil
+}
+
+// ParseLevel parse a level name, empty is info
+func ParseLevel(text string) (zapcore.Level, error) {
+	var l zapcore.Level
+	if err := l.UnmarshalText([]byte(strings.ToLower(text))); err != nil {
+		return l, fmt.Errorf("logger: %w", err)
+	}
+	return l, nil
+}
+
+// ParseEncoder parse an encoder name, empty is console
+func ParseEncoder(text string) (Encoder, error) {
+	switch strings.ToLower(text) {
+	case "", "console":
+		return ConsoleEncoder, nil
+	case "json":
+		return JSONEncode, nil
+	}
+	return ConsoleEncoder, fmt.Errorf("logger: unknown encoder %q", text)
+}
+
+func newSinkCore(c *Config, sc *SinkConfig) (zapcore.Core, error) {
+	encoderName := sc.Encoder
+	if encoderName == "" {
+		encoderName = c.Encoder
+	}
+	encoderType, err := ParseEncoder(encoderName)
+	if err != nil {
+		return nil, err
+	}
+
+	// the logger level is applied on top by levelCore
+	var enabler zapcore.LevelEnabler = zapcore.DebugLevel
+	if sc.Level != "" {
+		if enabler, err = ParseLevel(sc.Level); err != nil {
+			return nil, err
+		}
+	}
+
+	var w zapcore.WriteSyncer
+	switch sc.Type {
+	case StdoutSink:
+		w = zapcore.AddSync(os.Stdout)
+	case StderrSink:
+		w = zapcore.AddSync(os.Stderr)
+	case FileSink, "":
+		if sc.Filename == "" {
+			if sc.Type == FileSink {
+				return nil, fmt.Errorf("logger: file sink without filename")
+			}
+			w = zapcore.AddSync(os.Stdout)
+			break
+		}
+		w = zapcore.AddSync(sc.FileConfig.writer(sc.Filename))
+	default:
+		return nil, fmt.Errorf("logger: unknown sink type %q", sc.Type)
+	}
+	return zapcore.NewCore(NewEncoder(encoderType), w, enabler), nil
+}
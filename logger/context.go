@@ -0,0 +1,121 @@
+package logger
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// keys used to carry ContextInfo over http headers, grpc metadata and amqp headers
+const (
+	RequestIDKey = "x-request-id"
+	UserKey      = "x-user-id"
+	TraceIDKey   = "x-trace-id"
+	SpanIDKey    = "x-span-id"
+)
+
+type ctxInfoKey struct{}
+
+// ContextInfo request identity carried by context
+type ContextInfo struct {
+	RequestID string
+	User      string
+	TraceID   string
+	SpanID    string
+}
+
+// NewContext return a copy of ctx carrying info, empty values of info keep the existing ones
+func NewContext(ctx context.Context, info ContextInfo) context.Context {
+	old := FromContext(ctx)
+	if info.RequestID == "" {
+		info.RequestID = old.RequestID
+	}
+	if info.User == "" {
+		info.User = old.User
+	}
+	if info.TraceID == "" {
+		info.TraceID = old.TraceID
+	}
+	if info.SpanID == "" {
+		info.SpanID = old.SpanID
+	}
+	return context.WithValue(ctx, ctxInfoKey{}, info)
+}
+
+// FromContext return the ContextInfo carried by ctx
+func FromContext(ctx context.Context) ContextInfo {
+	if ctx == nil {
+		return ContextInfo{}
+	}
+	info, _ := ctx.Value(ctxInfoKey{}).(ContextInfo)
+	return info
+}
+
+// WithRequestID return a copy of ctx carrying the request id
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return NewContext(ctx, ContextInfo{RequestID: requestID})
+}
+
+// WithUser return a copy of ctx carrying the user identity
+func WithUser(ctx context.Context, user string) context.Context {
+	return NewContext(ctx, ContextInfo{User: user})
+}
+
+// WithTrace return a copy of ctx carrying the trace and span id
+func WithTrace(ctx context.Context, traceID, spanID string) context.Context {
+	return NewContext(ctx, ContextInfo{TraceID: traceID, SpanID: spanID})
+}
+
+// ContextFields return the non-empty ContextInfo of ctx as zap fields
+func ContextFields(ctx context.Context) []zap.Field {
+	info := FromContext(ctx)
+	fields := make([]zap.Field, 0, 4)
+	if info.RequestID != "" {
+		fields = append(fields, zap.String("request_id", info.RequestID))
+	}
+	if info.User != "" {
+		fields = append(fields, zap.String("user", info.User))
+	}
+	if info.TraceID != "" {
+		fields = append(fields, zap.String("trace_id", info.TraceID))
+	}
+	if info.SpanID != "" {
+		fields = append(fields, zap.String("span_id", info.SpanID))
+	}
+	return fields
+}
+
+// WithContext return the default logger with the ContextInfo fields of ctx
+func WithContext(ctx context.Context) *zap.Logger {
+	return baseLogger.With(ContextFields(ctx)...)
+}
+
+// Ctx short for WithContext
+func Ctx(ctx context.Context) *zap.Logger {
+	return WithContext(ctx)
+}
+
+// Inject write the ContextInfo of ctx through set, e.g. into headers or metadata
+func Inject(ctx context.Context, set func(key, value string)) {
+	info := FromContext(ctx)
+	for _, kv := range [][2]string{
+		{RequestIDKey, info.RequestID},
+		{UserKey, info.User},
+		{TraceIDKey, info.TraceID},
+		{SpanIDKey, info.SpanID},
+	} {
+		if kv[1] != "" {
+			set(kv[0], kv[1])
+		}
+	}
+}
+
+// Extract return a copy of ctx carrying the request and trace ids read through get.
+// The user is not extracted, any caller could forge it, the authenticated code sets it with WithUser
+func Extract(ctx context.Context, get func(key string) string) context.Context {
+	return NewContext(ctx, ContextInfo{
+		RequestID: get(RequestIDKey),
+		TraceID:   get(TraceIDKey),
+		SpanID:    get(SpanIDKey),
+	})
+}
@@ -0,0 +1,193 @@
+package logger
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// resetLevel level value making a named logger follow the default level again
+const resetLevel = "default"
+
+var (
+	errLevel   = errors.New("invalid level")
+	errTimeout = errors.New("invalid timeout")
+	errName    = errors.New("named loggers are only supported by the default level handler")
+)
+
+var defaultLevelHandler = &LevelHandler{level: atomicLevel, named: true}
+
+type levelPayload struct {
+	Name    string `json:"name,omitempty"`
+	Level   string `json:"level"`
+	Timeout string `json:"timeout,omitempty"`
+}
+
+type levelResponse struct {
+	Name     string            `json:"name,omitempty"`
+	Level    string            `json:"level,omitempty"`
+	RevertAt *time.Time        `json:"revert_at,omitempty"`
+	Named    map[string]string `json:"named,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// reverter apply a change and restore the previous state once its timeout expires
+type reverter struct {
+	mu       sync.Mutex
+	timer    *time.Timer
+	restore  func()
+	revertAt *time.Time
+}
+
+// set call apply, save captures the state to restore if timeout > 0,
+// a pending revert is cancelled but keeps its original state
+func (r *reverter) set(timeout time.Duration, save func() func(), apply func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	restore := r.restore
+	if r.timer != nil {
+		r.timer.Stop()
+	} else {
+		restore = save()
+	}
+	r.timer, r.restore, r.revertAt = nil, nil, nil
+	apply()
+	if timeout <= 0 {
+		return
+	}
+	revertAt := time.Now().Add(timeout)
+	var timer *time.Timer
+	timer = time.AfterFunc(timeout, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.timer != timer {
+			return
+		}
+		r.restore()
+		r.timer, r.restore, r.revertAt = nil, nil, nil
+	})
+	r.timer, r.restore, r.revertAt = timer, restore, &revertAt
+}
+
+func (r *reverter) pending() *time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.revertAt
+}
+
+// LevelHandler reads and changes a level over http
+//
+// GET returns the current level,
+// PUT changes it with a json body {"level": "debug", "timeout": "10m"}
+// or the same query params, a positive timeout reverts the level after it expires.
+// The default handler also manages the named loggers with a name,
+// e.g. {"name": "dbgo", "level": "debug"}, level "default" makes it follow the default level again.
+type LevelHandler struct {
+	level    zap.AtomicLevel
+	reverter reverter
+	named    bool
+}
+
+// NewLevelHandler return a handler of level
+func NewLevelHandler(level zap.AtomicLevel) *LevelHandler {
+	return &LevelHandler{level: level}
+}
+
+// DefaultLevelHandler return the handler of the default logger level and the named logger levels
+func DefaultLevelHandler() *LevelHandler {
+	return defaultLevelHandler
+}
+
+func (h *LevelHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var err error
+		if name, err = h.update(r); err != nil {
+			writeLevel(w, http.StatusBadRequest, levelResponse{Error: err.Error()})
+			return
+		}
+	default:
+		writeLevel(w, http.StatusMethodNotAllowed, levelResponse{Error: "only GET and PUT are supported"})
+		return
+	}
+	if name != "" && !h.named {
+		writeLevel(w, http.StatusBadRequest, levelResponse{Error: errName.Error()})
+		return
+	}
+	var resp levelResponse
+	if name != "" {
+		nl := registry.get(name)
+		resp = levelResponse{Name: name, Level: nl.Level().String(), RevertAt: nl.reverter.pending()}
+	} else {
+		resp = levelResponse{Level: h.level.Level().String(), RevertAt: h.reverter.pending()}
+		if h.named {
+			resp.Named = make(map[string]string)
+			for n, l := range NamedLevels() {
+				resp.Named[n] = l.String()
+			}
+		}
+	}
+	writeLevel(w, http.StatusOK, resp)
+}
+
+// SetLevel change the level, revert to the previous one after timeout if timeout > 0
+func (h *LevelHandler) SetLevel(l zapcore.Level, timeout time.Duration) {
+	h.reverter.set(timeout, func() func() {
+		prev := h.level.Level()
+		return func() {
+			h.level.SetLevel(prev)
+		}
+	}, func() {
+		h.level.SetLevel(l)
+	})
+}
+
+// update apply a PUT request and return the name of the changed named logger
+func (h *LevelHandler) update(r *http.Request) (string, error) {
+	var p levelPayload
+	if r.URL.Query().Get("level") != "" {
+		p.Name = r.URL.Query().Get("name")
+		p.Level = r.URL.Query().Get("level")
+		p.Timeout = r.URL.Query().Get("timeout")
+	} else if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		return "", err
+	}
+	if p.Name != "" && !h.named {
+		return "", errName
+	}
+	if p.Name != "" && p.Level == resetLevel {
+		ResetNamedLevel(p.Name)
+		return p.Name, nil
+	}
+	var l zapcore.Level
+	if err := l.UnmarshalText([]byte(p.Level)); err != nil || p.Level == "" {
+		return "", errLevel
+	}
+	var timeout time.Duration
+	if p.Timeout != "" {
+		var err error
+		timeout, err = time.ParseDuration(p.Timeout)
+		if err != nil || timeout < 0 {
+			return "", errTimeout
+		}
+	}
+	if p.Name != "" {
+		setNamedLevel(p.Name, l, timeout)
+	} else {
+		h.SetLevel(l, timeout)
+	}
+	return p.Name, nil
+}
+
+func writeLevel(w http.ResponseWriter, code int, resp levelResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(resp)
+}
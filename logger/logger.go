@@ -1,13 +1,9 @@
 package logger
 
 import (
-	"io"
-	"log"
 	"os"
 	"strings"
-	"time"
 
-	rotateLogs "github.com/lestrrat-go/file-rotatelogs"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -22,16 +18,109 @@ const (
 	JSONEncode
 )
 
-var logger = SetUp(zapcore.InfoLevel, "", ConsoleEncoder, zap.AddCallerSkip(1), zap.AddCaller())
+var (
+	// atomicLevel level of the default logger, kept across InitLogger calls
+	atomicLevel = zap.NewAtomicLevelAt(zapcore.InfoLevel)
+	// baseLogger the default logger without the caller skip of the package level functions, for WithContext
+	baseLogger *zap.Logger
+	logger     = initDefault()
+	// defaultAsync async core of the default logger, nil if it writes synchronously
+	defaultAsync *AsyncCore
+)
+
+func initDefault() *zap.Logger {
+	base := newSetUpCore(zapcore.InfoLevel, nil, ConsoleEncoder)
+	defaultBase.Store(&baseCore{Core: base, caller: true})
+	baseLogger = zap.New(&levelCore{Core: base, level: atomicLevel}, zap.AddCaller())
+	return baseLogger.WithOptions(zap.AddCallerSkip(1))
+}
 
 // InitLogger init default logger
 func InitLogger(logLevel zapcore.Level, filepath string, encoderType Encoder, opts ...zap.Option) {
-	logger = SetUp(logLevel, filepath, encoderType, opts...)
+	var fc *FileConfig
+	if filepath != "" {
+		fc = &FileConfig{Filename: filepath}
+	}
+	InitFileLogger(logLevel, fc, encoderType, opts...)
+}
+
+// InitFileLogger init default logger with file rotation config,
+// opts include the caller skip of the package level functions, e.g. zap.AddCallerSkip(1)
+func InitFileLogger(logLevel zapcore.Level, fc *FileConfig, encoderType Encoder, opts ...zap.Option) {
+	atomicLevel.SetLevel(logLevel)
+	base := newSetUpCore(logLevel, fc, encoderType)
+	opts = append(opts, zap.AddCallerSkip(-1))
+	setDefault(zap.New(&levelCore{Core: base, level: atomicLevel}, opts...), base, nil)
+}
+
+// setDefault replace the default logger, built from l with one frame skipped for the package level functions,
+// and the base core of the named loggers, the previous sampling and async cores are flushed and stopped
+func setDefault(l *zap.Logger, base zapcore.Core, async *AsyncCore) {
+	prev, prevBase := defaultAsync, defaultBase.Load().(*baseCore)
+	baseLogger, logger, defaultAsync = l, l.WithOptions(zap.AddCallerSkip(1)), async
+	defaultBase.Store(&baseCore{Core: base, caller: hasCaller(l)})
+	// the sampling core writes its pending summary before the async core it wraps is stopped
+	if sampling, ok := prevBase.Core.(*SamplingCore); ok {
+		_ = sampling.Close()
+	}
+	if prev != nil {
+		_ = prev.Close()
+	}
 }
 
-// SetUp logger
+// ReplaceCore replace the outputs of the default logger and of the named loggers with core,
+// the levels are kept, restore puts the previous outputs back, e.g. for tests
+func ReplaceCore(core zapcore.Core) (restore func()) {
+	prevBaseLogger, prevLogger, prevAsync, prevBase := baseLogger, logger, defaultAsync, defaultBase.Load()
+	baseLogger = zap.New(&levelCore{Core: core, level: atomicLevel}, zap.AddCaller())
+	logger, defaultAsync = baseLogger.WithOptions(zap.AddCallerSkip(1)), nil
+	defaultBase.Store(&baseCore{Core: core, caller: true})
+	return func() {
+		baseLogger, logger, defaultAsync = prevBaseLogger, prevLogger, prevAsync
+		defaultBase.Store(prevBase)
+	}
+}
+
+// SetUp logger, filepath uses the default rotation config
 func SetUp(logLevel zapcore.Level, filepath string, encoderType Encoder, opts ...zap.Option) *zap.Logger {
-	var encoder zapcore.Encoder
+	var fc *FileConfig
+	if filepath != "" {
+		fc = &FileConfig{Filename: filepath}
+	}
+	return SetUpFile(logLevel, fc, encoderType, opts...)
+}
+
+// SetUpFile logger, fc may be nil to log to stdout only
+func SetUpFile(logLevel zapcore.Level, fc *FileConfig, encoderType Encoder, opts ...zap.Option) *zap.Logger {
+	return SetUpLevel(zap.NewAtomicLevelAt(logLevel), fc, encoderType, opts...)
+}
+
+// SetUpLevel logger whose level can be changed at runtime through level
+func SetUpLevel(level zap.AtomicLevel, fc *FileConfig, encoderType Encoder, opts ...zap.Option) *zap.Logger {
+	core := newSetUpCore(level.Level(), fc, encoderType)
+	return zap.New(&levelCore{Core: core, level: level}, opts...)
+}
+
+// newSetUpCore outputs of SetUp, the level is applied on top by levelCore
+func newSetUpCore(logLevel zapcore.Level, fc *FileConfig, encoderType Encoder) zapcore.Core {
+	encoder := NewEncoder(encoderType)
+	var cores []zapcore.Core
+	// 输出到文件，按大小分割，error级别下的会把err日志单独输出到 _err.log
+	if fc != nil && fc.Filename != "" {
+		w := fc.writer(fc.Filename)
+		cores = append(cores, zapcore.NewCore(encoder, zapcore.AddSync(w), zapcore.DebugLevel))
+		if logLevel == zap.InfoLevel || logLevel == zap.DebugLevel {
+			errW := fc.writer(strings.TrimSuffix(fc.Filename, ".log") + "_err.log")
+			cores = append(cores, zapcore.NewCore(encoder, zapcore.AddSync(errW), zap.WarnLevel))
+		}
+	}
+	// 输出到终端
+	cores = append(cores, zapcore.NewCore(encoder, zapcore.AddSync(os.Stdout), zapcore.DebugLevel))
+	return zapcore.NewTee(cores...)
+}
+
+// NewEncoder return the encoder of encoderType used by the logger outputs
+func NewEncoder(encoderType Encoder) zapcore.Encoder {
 	// encoderConfig 编码控制
 	encoderConfig := zapcore.EncoderConfig{
 		TimeKey:        "time",
@@ -47,43 +136,10 @@ func SetUp(logLevel zapcore.Level, filepath string, encoderType Encoder, opts ..
 		EncodeCaller:   zapcore.ShortCallerEncoder,
 		EncodeName:     zapcore.FullNameEncoder,
 	}
-	level := zap.NewAtomicLevel()
-	level.SetLevel(logLevel)
 	if encoderType == JSONEncode {
-		encoder = zapcore.NewJSONEncoder(encoderConfig)
-	} else {
-		encoder = zapcore.NewConsoleEncoder(encoderConfig)
-	}
-	var cores []zapcore.Core
-	// 输出到文件，按天分割，error级别下的会把err日志单独输出到 _err.log
-	if filepath != "" {
-		w := writer(filepath)
-		cores = append(cores, zapcore.NewCore(encoder, zapcore.AddSync(w), level))
-		if logLevel == zap.InfoLevel || logLevel == zap.DebugLevel {
-			filepath = strings.TrimSuffix(filepath, ".log")
-			errW := writer(filepath + "_err.log")
-			cores = append(cores, zapcore.NewCore(encoder, zapcore.AddSync(errW), zap.WarnLevel))
-		}
-	}
-	// 输出到终端
-	cores = append(cores, zapcore.NewCore(encoder, zapcore.AddSync(os.Stdout), level))
-	core := zapcore.NewTee(cores...)
-	return zap.New(core, opts...)
-}
-
-func writer(filename string) io.Writer {
-	hook, err := rotateLogs.New(
-		filename+".%Y-%m-%d",
-		rotateLogs.WithLinkName(filename),
-		//nolint:gomnd
-		rotateLogs.WithMaxAge(time.Hour*24*14),
-		//nolint:gomnd
-		rotateLogs.WithRotationTime(time.Hour*24),
-	)
-	if err != nil {
-		log.Fatalf("init rotatelogs err: %+v", err)
+		return zapcore.NewJSONEncoder(encoderConfig)
 	}
-	return hook
+	return zapcore.NewConsoleEncoder(encoderConfig)
 }
 
 // func timeEncoder(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
@@ -119,6 +175,25 @@ func GetLogger() *zap.Logger {
 	return logger
 }
 
+// SetLevel change the level of the default logger, cancels a pending revert of the level handler
+func SetLevel(l zapcore.Level) {
+	defaultLevelHandler.SetLevel(l, 0)
+}
+
+// Level return the level of the default logger
+func Level() zapcore.Level {
+	return atomicLevel.Level()
+}
+
+// Sync flush the default logger, including the queued entries of an async logger
 func Sync() {
 	_ = logger.Sync()
 }
+
+// QueueStats return the state of the default logger async queue, ok is false if it writes synchronously
+func QueueStats() (stats AsyncStats, ok bool) {
+	if defaultAsync == nil {
+		return stats, false
+	}
+	return defaultAsync.Stats(), true
+}
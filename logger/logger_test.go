@@ -1,15 +1,416 @@
 package logger_test
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/happyxhw/gopkg/logger"
 
+	"github.com/spf13/viper"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestError(t *testing.T) {
 	logger.InitLogger(zap.InfoLevel, "", logger.ConsoleEncoder, zap.AddCallerSkip(1), zap.AddCaller())
 	logger.Error("hello", zap.String("key", "value"), zap.Error(errors.New("test")))
 }
+
+func TestFileLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "test.log")
+	l := logger.SetUpFile(zap.InfoLevel, &logger.FileConfig{
+		Filename:   filename,
+		MaxSize:    1,
+		MaxBackups: 3,
+		Compress:   true,
+	}, logger.JSONEncode)
+	l.Info("info")
+	l.Warn("warn")
+	_ = l.Sync()
+
+	for _, name := range []string{"test.log", "test_err.log"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestLevelHandler(t *testing.T) {
+	level := zap.NewAtomicLevelAt(zap.InfoLevel)
+	h := logger.NewLevelHandler(level)
+
+	req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"level":"debug","timeout":"50ms"}`))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || level.Level() != zap.DebugLevel {
+		t.Fatalf("code: %d, level: %s", w.Code, level.Level())
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if level.Level() != zap.InfoLevel {
+		t.Errorf("level not reverted: %s", level.Level())
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/?level=wrong", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("code: %d", w.Code)
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	defer logger.SetLevel(logger.Level())
+	logger.SetLevel(zap.ErrorLevel)
+	if logger.Level() != zap.ErrorLevel || logger.GetLogger().Core().Enabled(zap.WarnLevel) {
+		t.Errorf("level: %s", logger.Level())
+	}
+}
+
+func TestContext(t *testing.T) {
+	ctx := logger.WithRequestID(context.Background(), "req")
+	ctx = logger.WithUser(ctx, "user")
+	ctx = logger.WithTrace(ctx, "trace", "")
+
+	header := http.Header{}
+	logger.Inject(ctx, header.Set)
+	info := logger.FromContext(logger.Extract(context.Background(), header.Get))
+	if info != (logger.ContextInfo{RequestID: "req", TraceID: "trace"}) {
+		t.Errorf("info: %+v", info)
+	}
+	if fields := logger.ContextFields(ctx); len(fields) != 3 {
+		t.Errorf("fields: %v", fields)
+	}
+	logger.Ctx(ctx).Info("ctx")
+
+	// the caller is the caller of Ctx whatever the caller skip of the default logger
+	core, logs := observer.New(zap.InfoLevel)
+	if err := logger.Init(&logger.Config{Caller: true, Cores: []zapcore.Core{core}}); err != nil {
+		t.Fatal(err)
+	}
+	logger.Ctx(ctx).Info("ctx")
+	logger.Info("default")
+	restore := logger.ReplaceCore(core)
+	logger.Ctx(ctx).Info("replaced")
+	restore()
+	for _, e := range logs.All() {
+		if !strings.HasSuffix(e.Caller.File, "logger_test.go") {
+			t.Errorf("%s caller %s", e.Message, e.Caller)
+		}
+	}
+	if logs.Len() != 3 {
+		t.Errorf("%d entries", logs.Len())
+	}
+}
+
+func TestConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	yml := `
+log:
+  level: debug
+  encoder: json
+  caller: true
+  sinks:
+    - type: stdout
+      encoder: console
+    - filename: ` + filepath.Join(dir, "app.log") + `
+      max_size: 10
+      compress: true
+    - type: file
+      filename: ` + filepath.Join(dir, "app_err.log") + `
+      level: warn
+`
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err = v.ReadConfig(strings.NewReader(yml)); err != nil {
+		t.Fatal(err)
+	}
+	var c logger.Config
+	if err = v.UnmarshalKey("log", &c); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Sinks) != 3 || c.Sinks[1].MaxSize != 10 || !c.Sinks[1].Compress {
+		t.Fatalf("config: %+v", c)
+	}
+
+	l, err := logger.New(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Debug("debug")
+	l.Warn("warn")
+	_ = l.Sync()
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "app_err.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "debug") || !strings.Contains(string(data), "warn") {
+		t.Errorf("err log: %s", data)
+	}
+
+	if _, err = logger.New(&logger.Config{Sinks: []logger.SinkConfig{{Type: "kafka"}}}); err == nil {
+		t.Error("expect unknown sink error")
+	}
+}
+
+func TestAsyncCore(t *testing.T) {
+	buf := &bytes.Buffer{}
+	var mu sync.Mutex
+	w := zapcore.AddSync(writerFunc(func(p []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return buf.Write(p)
+	}))
+	enc := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	core, err := logger.NewAsyncCore(zapcore.NewCore(enc, w, zap.DebugLevel), &logger.AsyncConfig{
+		QueueSize: 4,
+		Overflow:  "drop_newest",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := zap.New(core).With(zap.String("k", "v"))
+	for i := 0; i < 100; i++ {
+		l.Info("info", zap.Int("i", i))
+	}
+	if err = l.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	stats := core.Stats()
+	mu.Lock()
+	lines := strings.Count(buf.String(), "\n")
+	mu.Unlock()
+	if uint64(lines)+stats.Dropped != 100 || stats.DroppedByLevel[zap.InfoLevel] != stats.Dropped {
+		t.Errorf("lines: %d, stats: %+v", lines, stats)
+	}
+	if !strings.Contains(buf.String(), `"k":"v"`) {
+		t.Errorf("missing fields: %s", buf.String())
+	}
+
+	_ = core.Close()
+	l.Info("after close")
+	if !strings.Contains(buf.String(), "after close") {
+		t.Error("entry after close not written")
+	}
+
+	if _, err = logger.NewAsyncCore(zapcore.NewNopCore(), &logger.AsyncConfig{Overflow: "wrong"}); err == nil {
+		t.Error("expect overflow policy error")
+	}
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func TestSampling(t *testing.T) {
+	buf := &bytes.Buffer{}
+	enc := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	l := logger.SetUpLevel(zap.NewAtomicLevel(), nil, logger.JSONEncode,
+		zap.WrapCore(func(zapcore.Core) zapcore.Core {
+			return zapcore.NewCore(enc, zapcore.AddSync(buf), zap.DebugLevel)
+		}),
+		logger.WithSampling(&logger.SamplingConfig{Interval: time.Minute, First: 2, Thereafter: 3}),
+	)
+	for i := 0; i < 10; i++ {
+		l.Warn("hot")
+	}
+	l.Info("cold")
+	_ = l.Sync()
+
+	out := buf.String()
+	if n := strings.Count(out, `"msg":"hot"`); n != 4 {
+		t.Errorf("hot lines: %d", n)
+	}
+	if !strings.Contains(out, `"msg":"cold"`) || !strings.Contains(out, `"suppressed":6`) ||
+		!strings.Contains(out, `"warn: hot":6`) {
+		t.Errorf("output: %s", out)
+	}
+}
+
+func TestSamplingReInit(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	err := logger.Init(&logger.Config{
+		Cores:    []zapcore.Core{core},
+		Sampling: &logger.SamplingConfig{Interval: time.Hour, First: 1},
+		Async:    &logger.AsyncConfig{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		logger.Warn("hot")
+	}
+	// the previous sampling core writes its summary to the previous outputs
+	if err := logger.Init(&logger.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	summary := logs.FilterMessage("log sampling suppressed entries").All()
+	if len(summary) != 1 || summary[0].ContextMap()["suppressed"] != uint64(4) {
+		t.Errorf("summary %v", summary)
+	}
+}
+
+func TestSamplingFlush(t *testing.T) {
+	buf := &bytes.Buffer{}
+	var mu sync.Mutex
+	w := zapcore.AddSync(writerFunc(func(p []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return buf.Write(p)
+	}))
+	enc := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	core := logger.NewSamplingCore(zapcore.NewCore(enc, w, zap.DebugLevel), &logger.SamplingConfig{
+		Interval: 10 * time.Millisecond,
+		First:    1,
+	})
+	defer core.Close()
+	l := zap.New(core)
+	for i := 0; i < 5; i++ {
+		l.Warn("hot")
+	}
+
+	// no more entry nor Sync, the summary is written by the ticker
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.Lock()
+		out := buf.String()
+		mu.Unlock()
+		if strings.Contains(out, `"suppressed":4`) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no summary: %s", out)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRedactor(t *testing.T) {
+	r, err := logger.NewRedactor(&logger.RedactConfig{Patterns: []string{`\d{16}`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		f        func(string) string
+		in, want string
+	}{
+		{r.Query, "a=1&token=abc&X-Api-Key=k", "a=1&token=******&X-Api-Key=******"},
+		{r.SQL, "UPDATE `users` SET `password`='hash' WHERE email = 'a@b.c'", "UPDATE `users` SET `password`='******' WHERE email = 'a@b.c'"},
+		{
+			r.SQL,
+			`INSERT INTO "users" ("user_name","email","password") VALUES ('a','a@b.c','x,''y'),('b',lower('B'),'z')`,
+			`INSERT INTO "users" ("user_name","email","password") VALUES ('a','a@b.c','******'),('b',lower('B'),'******')`,
+		},
+		{r.String, "card 1234123412341234", "card ******"},
+		{r.Query, "status_code=500&error_code=E1&oauth_code=c", "status_code=500&error_code=E1&oauth_code=******"},
+	}
+	for _, c := range cases {
+		if got := c.f(c.in); got != c.want {
+			t.Errorf("got: %s, want: %s", got, c.want)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	enc := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	l := zap.New(zapcore.NewCore(enc, zapcore.AddSync(buf), zap.DebugLevel), logger.WithRedaction(r))
+	l.With(zap.String("db_password", "p")).Info("login", zap.String("user", "u"), zap.Int("code", 200))
+	if out := buf.String(); !strings.Contains(out, `"db_password":"******"`) ||
+		!strings.Contains(out, `"user":"u"`) || !strings.Contains(out, `"code":200`) {
+		t.Errorf("output: %s", out)
+	}
+
+	// every field type is masked by key
+	buf.Reset()
+	l.Info("login",
+		zap.Int("pwd", 1234),
+		zap.Any("secret", map[string]string{"k": "v"}),
+		zap.Binary("token", []byte("t")),
+		zap.Reflect("api_key", []string{"k"}),
+		zap.Object("jwt", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			enc.AddString("sub", "u")
+			return nil
+		})),
+	)
+	for _, key := range []string{"pwd", "secret", "token", "api_key", "jwt"} {
+		if out := buf.String(); !strings.Contains(out, `"`+key+`":"******"`) {
+			t.Errorf("%s not masked: %s", key, out)
+		}
+	}
+
+	failing := zapcore.NewCore(enc, zapcore.AddSync(failWriter{}), zap.DebugLevel)
+	if err := zap.New(failing, logger.WithRedaction(r)).Core().Write(zapcore.Entry{Message: "lost"}, nil); err == nil {
+		t.Error("write error dropped")
+	}
+}
+
+type failWriter struct{}
+
+func (failWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestNamed(t *testing.T) {
+	l := logger.Named("test_named")
+	if logger.NamedLevel("test_named") != logger.Level() {
+		t.Fatalf("named level %s should follow %s", logger.NamedLevel("test_named"), logger.Level())
+	}
+	logger.SetNamedLevel("test_named", zap.DebugLevel)
+	if !l.Core().Enabled(zap.DebugLevel) || logger.GetLogger().Core().Enabled(zap.DebugLevel) {
+		t.Error("named level should be independent")
+	}
+
+	// named loggers keep working after Init
+	if err := logger.Init(&logger.Config{Levels: map[string]string{"test_named": "error"}}); err != nil {
+		t.Fatal(err)
+	}
+	if l.Core().Enabled(zap.WarnLevel) || !l.Core().Enabled(zap.ErrorLevel) {
+		t.Errorf("level: %s", logger.NamedLevel("test_named"))
+	}
+	l.Error("named")
+
+	req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"name":"test_named","level":"default"}`))
+	w := httptest.NewRecorder()
+	logger.DefaultLevelHandler().ServeHTTP(w, req)
+	if w.Code != http.StatusOK || logger.NamedLevel("test_named") != logger.Level() {
+		t.Errorf("code: %d, level: %s", w.Code, logger.NamedLevel("test_named"))
+	}
+}
+
+func TestNamedCaller(t *testing.T) {
+	l := logger.Named("test_named_caller")
+	for _, caller := range []bool{false, true} {
+		core, logs := observer.New(zap.InfoLevel)
+		if err := logger.Init(&logger.Config{Caller: caller, Cores: []zapcore.Core{core}}); err != nil {
+			t.Fatal(err)
+		}
+		l.Info("named")
+		if entries := logs.All(); len(entries) != 1 || entries[0].Caller.Defined != caller {
+			t.Errorf("caller %t: %v", caller, entries)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Package loggertest capture the entries of the default logger and the named loggers in tests
+package loggertest
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/happyxhw/gopkg/logger"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+var (
+	mu      sync.Mutex
+	current *Logs
+)
+
+// Logs entries captured from the default logger
+type Logs struct {
+	*observer.ObservedLogs
+}
+
+// Observe capture the entries of the default logger at its current level until the test ends, e.g.
+//
+//	logs := loggertest.Observe(t)
+//	logger.Info("created", zap.Int("id", 1))
+//	loggertest.AssertLogged(t, zapcore.InfoLevel, "created", zap.Int("id", 1))
+func Observe(t testing.TB) *Logs {
+	t.Helper()
+	core, observed := observer.New(zapcore.DebugLevel)
+	logs := &Logs{ObservedLogs: observed}
+	restore := logger.ReplaceCore(core)
+	mu.Lock()
+	prev := current
+	current = logs
+	mu.Unlock()
+	t.Cleanup(func() {
+		restore()
+		mu.Lock()
+		current = prev
+		mu.Unlock()
+	})
+	return logs
+}
+
+// ObserveLevel same as Observe but set the default level to level until the test ends
+func ObserveLevel(t testing.TB, level zapcore.Level) *Logs {
+	t.Helper()
+	prev := logger.Level()
+	logger.SetLevel(level)
+	t.Cleanup(func() {
+		logger.SetLevel(prev)
+	})
+	return Observe(t)
+}
+
+// AssertLogged fail t unless the current observer captured an entry of level and msg
+// carrying fields, the entry may carry other fields
+func AssertLogged(t testing.TB, level zapcore.Level, msg string, fields ...zap.Field) {
+	t.Helper()
+	mustCurrent(t).AssertLogged(t, level, msg, fields...)
+}
+
+// AssertNotLogged fail t if the current observer captured an entry of level and msg
+func AssertNotLogged(t testing.TB, level zapcore.Level, msg string) {
+	t.Helper()
+	mustCurrent(t).AssertNotLogged(t, level, msg)
+}
+
+// AssertLogged fail t unless l captured an entry of level and msg carrying fields
+func (l *Logs) AssertLogged(t testing.TB, level zapcore.Level, msg string, fields ...zap.Field) {
+	t.Helper()
+	if len(l.Find(level, msg, fields...)) > 0 {
+		return
+	}
+	t.Errorf("no %s entry %q with fields %v, captured:\n%s", level, msg, fieldMap(fields), l)
+}
+
+// AssertNotLogged fail t if l captured an entry of level and msg
+func (l *Logs) AssertNotLogged(t testing.TB, level zapcore.Level, msg string) {
+	t.Helper()
+	if found := l.Find(level, msg); len(found) > 0 {
+		t.Errorf("unexpected %s entry %q with fields %v", level, msg, found[0].ContextMap())
+	}
+}
+
+// Find return the entries of level and msg carrying fields
+func (l *Logs) Find(level zapcore.Level, msg string, fields ...zap.Field) []observer.LoggedEntry {
+	want := fieldMap(fields)
+	var found []observer.LoggedEntry
+	for _, e := range l.All() {
+		if e.Level != level || e.Message != msg {
+			continue
+		}
+		if hasFields(e.ContextMap(), want) {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+// String list the captured entries one per line
+func (l *Logs) String() string {
+	var b strings.Builder
+	for _, e := range l.All() {
+		fmt.Fprintf(&b, "\t%s %s %q %v\n", e.Level, e.LoggerName, e.Message, e.ContextMap())
+	}
+	return b.String()
+}
+
+func mustCurrent(t testing.TB) *Logs {
+	t.Helper()
+	mu.Lock()
+	defer mu.Unlock()
+	if current == nil {
+		t.Fatal("loggertest: Observe not called")
+	}
+	return current
+}
+
+func fieldMap(fields []zap.Field) map[string]interface{} {
+	enc := zapcore.NewMapObjectEncoder()
+	for i := range fields {
+		fields[i].AddTo(enc)
+	}
+	return enc.Fields
+}
+
+func hasFields(got, want map[string]interface{}) bool {
+	for k, v := range want {
+		if g, ok := got[k]; !ok || !reflect.DeepEqual(g, v) {
+			return false
+		}
+	}
+	return true
+}
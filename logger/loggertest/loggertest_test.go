@@ -0,0 +1,39 @@
+package loggertest_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/happyxhw/gopkg/logger"
+	"github.com/happyxhw/gopkg/logger/loggertest"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestObserve(t *testing.T) {
+	t.Run("capture", func(t *testing.T) {
+		logs := loggertest.ObserveLevel(t, zapcore.DebugLevel)
+		logger.Debug("debug", zap.Int("id", 1))
+		logger.Named("dbgo").With(zap.String("table", "user")).Error("query", zap.Int("rows", 0))
+		logger.Ctx(logger.WithRequestID(context.Background(), "req")).Info("ctx")
+
+		loggertest.AssertLogged(t, zapcore.DebugLevel, "debug", zap.Int("id", 1))
+		loggertest.AssertLogged(t, zapcore.ErrorLevel, "query", zap.String("table", "user"))
+		loggertest.AssertLogged(t, zapcore.InfoLevel, "ctx", zap.String("request_id", "req"))
+		loggertest.AssertNotLogged(t, zapcore.InfoLevel, "debug")
+		if n := len(logs.Find(zapcore.DebugLevel, "debug", zap.Int("id", 2))); n != 0 {
+			t.Errorf("found %d entries with a wrong field", n)
+		}
+		if e := logs.All()[0]; e.Caller.File == "" {
+			t.Error("no caller")
+		}
+	})
+	if logger.Level() != zapcore.InfoLevel {
+		t.Errorf("level not restored: %s", logger.Level())
+	}
+	logs := loggertest.Observe(t)
+	logger.Debug("debug")
+	if logs.Len() != 0 {
+		t.Errorf("captured %d entries below the level", logs.Len())
+	}
+}
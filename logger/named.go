@@ -0,0 +1,236 @@
+package logger
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// defaultBase *baseCore, the core of the default logger without its level, shared by the named loggers
+var defaultBase atomic.Value
+
+var registry = namedRegistry{levels: make(map[string]*namedLevel)}
+
+type baseCore struct {
+	zapcore.Core
+	// caller whether the default logger adds the caller
+	caller bool
+}
+
+// levelCore apply a level on top of a core
+type levelCore struct {
+	zapcore.Core
+	level zapcore.LevelEnabler
+}
+
+func (c *levelCore) Enabled(l zapcore.Level) bool {
+	return c.level.Enabled(l) && c.Core.Enabled(l)
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), level: c.level}
+}
+
+func (c *levelCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.level.Enabled(ent.Level) {
+		return ce
+	}
+	return c.Core.Check(ent, ce)
+}
+
+// namedLevel level of a named logger, follows the default level until it is set
+type namedLevel struct {
+	own      int32 // atomic, 1 if level is used
+	level    zap.AtomicLevel
+	reverter reverter
+}
+
+func (l *namedLevel) Enabled(lvl zapcore.Level) bool {
+	return l.Level().Enabled(lvl)
+}
+
+func (l *namedLevel) Level() zapcore.Level {
+	if atomic.LoadInt32(&l.own) == 1 {
+		return l.level.Level()
+	}
+	return atomicLevel.Level()
+}
+
+func (l *namedLevel) set(lvl zapcore.Level, own bool) {
+	l.level.SetLevel(lvl)
+	if own {
+		atomic.StoreInt32(&l.own, 1)
+	} else {
+		atomic.StoreInt32(&l.own, 0)
+	}
+}
+
+func (l *namedLevel) save() func() {
+	own, lvl := atomic.LoadInt32(&l.own) == 1, l.level.Level()
+	return func() {
+		l.set(lvl, own)
+	}
+}
+
+type namedRegistry struct {
+	mu     sync.RWMutex
+	levels map[string]*namedLevel
+}
+
+func (r *namedRegistry) get(name string) *namedLevel {
+	r.mu.RLock()
+	l, ok := r.levels[name]
+	r.mu.RUnlock()
+	if ok {
+		return l
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if l, ok = r.levels[name]; !ok {
+		l = &namedLevel{level: zap.NewAtomicLevel()}
+		r.levels[name] = l
+	}
+	return l
+}
+
+// namedCore write to the current default base core, so that named loggers survive Init
+type namedCore struct {
+	level  *namedLevel
+	fields []zapcore.Field
+	cache  atomic.Value // *namedCache
+}
+
+type namedCache struct {
+	base *baseCore
+	core zapcore.Core
+}
+
+func (c *namedCore) core() zapcore.Core {
+	base := defaultBase.Load().(*baseCore)
+	if cache, ok := c.cache.Load().(*namedCache); ok && cache.base == base {
+		return cache.core
+	}
+	var core zapcore.Core = base
+	if len(c.fields) > 0 {
+		core = base.With(c.fields)
+	}
+	c.cache.Store(&namedCache{base: base, core: core})
+	return core
+}
+
+func (c *namedCore) Enabled(l zapcore.Level) bool {
+	return c.level.Enabled(l) && c.core().Enabled(l)
+}
+
+func (c *namedCore) With(fields []zapcore.Field) zapcore.Core {
+	all := make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	all = append(all, c.fields...)
+	return &namedCore{level: c.level, fields: append(all, fields...)}
+}
+
+func (c *namedCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.level.Enabled(ent.Level) {
+		return ce
+	}
+	if defaultBase.Load().(*baseCore).caller {
+		return c.core().Check(ent, ce)
+	}
+	// the caller is added after Check, Write drops it
+	if c.core().Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *namedCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	if !defaultBase.Load().(*baseCore).caller {
+		ent.Caller = zapcore.EntryCaller{}
+	}
+	return writeEntry(c.core(), ent, fields)
+}
+
+func (c *namedCore) Sync() error {
+	return c.core().Sync()
+}
+
+// Named return a logger named name writing to the outputs of the default logger,
+// with its own level that follows the default level until set by SetNamedLevel.
+// It keeps working after the default logger is replaced by Init and adds the caller only if the default logger does.
+func Named(name string, opts ...zap.Option) *zap.Logger {
+	opts = append([]zap.Option{zap.AddCaller()}, opts...)
+	return zap.New(&namedCore{level: registry.get(name)}, opts...).Named(name)
+}
+
+// SetNamedLevel set the level of the named logger
+func SetNamedLevel(name string, l zapcore.Level) {
+	setNamedLevel(name, l, 0)
+}
+
+// ResetNamedLevel make the named logger follow the default level again
+func ResetNamedLevel(name string) {
+	nl := registry.get(name)
+	nl.reverter.set(0, nl.save, func() {
+		nl.set(atomicLevel.Level(), false)
+	})
+}
+
+// NamedLevel return the effective level of the named logger
+func NamedLevel(name string) zapcore.Level {
+	return registry.get(name).Level()
+}
+
+// NamedLevels return the names of the named loggers with their effective levels
+func NamedLevels() map[string]zapcore.Level {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	levels := make(map[string]zapcore.Level, len(registry.levels))
+	for name, l := range registry.levels {
+		levels[name] = l.Level()
+	}
+	return levels
+}
+
+// hasCaller report whether l adds the caller to its entries
+func hasCaller(l *zap.Logger) bool {
+	p := &callerProbe{}
+	l.WithOptions(zap.WrapCore(func(zapcore.Core) zapcore.Core {
+		return p
+	})).Info("")
+	return p.caller
+}
+
+// callerProbe core recording whether an entry has a caller, the caller is set after Check
+type callerProbe struct {
+	caller bool
+}
+
+func (p *callerProbe) Enabled(zapcore.Level) bool {
+	return true
+}
+
+func (p *callerProbe) With([]zapcore.Field) zapcore.Core {
+	return p
+}
+
+func (p *callerProbe) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return ce.AddCore(ent, p)
+}
+
+func (p *callerProbe) Write(ent zapcore.Entry, _ []zapcore.Field) error {
+	p.caller = ent.Caller.Defined
+	return nil
+}
+
+func (p *callerProbe) Sync() error {
+	return nil
+}
+
+func setNamedLevel(name string, l zapcore.Level, timeout time.Duration) {
+	nl := registry.get(name)
+	nl.reverter.set(timeout, nl.save, func() {
+		nl.set(l, true)
+	})
+}
@@ -0,0 +1,280 @@
+package logger
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const defaultMask = "******"
+
+// DefaultRedactKeys names of fields, query params and sql columns masked by default
+var DefaultRedactKeys = []string{
+	"password", "passwd", "pwd", "secret", "token", "access_token", "refresh_token",
+	"authorization", "api_key", "apikey", "jwt", "oauth_code", "auth_code",
+}
+
+var (
+	// col = 'literal'
+	sqlCompareRe = regexp.MustCompile("(?i)([`\"\\w.]+)(\\s*(?:=|!=|<>|\\bLIKE\\b)\\s*)('(?:[^']|'')*')")
+	// INSERT INTO table (cols) VALUES (...), (...)
+	sqlInsertRe = regexp.MustCompile(`(?is)^(\s*INSERT\s+INTO\s+\S+\s*\(([^)]*)\)\s*VALUES\s*)(.*)$`)
+)
+
+// RedactConfig redaction config
+type RedactConfig struct {
+	// Keys names to mask, matched case-insensitively, as a whole or as the `_` suffix,
+	// e.g. password matches db_password, default DefaultRedactKeys
+	Keys []string
+	// Patterns regexes whose matches are masked in messages and string values
+	Patterns []string
+	// Mask replacement, default ******
+	Mask string
+}
+
+// Redactor mask sensitive data
+type Redactor struct {
+	keys     []string
+	patterns []*regexp.Regexp
+	mask     string
+}
+
+var defaultRedactor atomic.Value
+
+func init() {
+	r, _ := NewRedactor(&RedactConfig{})
+	defaultRedactor.Store(r)
+}
+
+// NewRedactor create a Redactor from c
+func NewRedactor(c *RedactConfig) (*Redactor, error) {
+	keys := c.Keys
+	if len(keys) == 0 {
+		keys = DefaultRedactKeys
+	}
+	r := Redactor{
+		keys: make([]string, 0, len(keys)),
+		mask: c.Mask,
+	}
+	if r.mask == "" {
+		r.mask = defaultMask
+	}
+	for _, k := range keys {
+		r.keys = append(r.keys, normalizeKey(k))
+	}
+	for _, p := range c.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("logger: redact pattern %q: %w", p, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return &r, nil
+}
+
+// DefaultRedactor return the redactor used by GinZap and the gorm logger
+func DefaultRedactor() *Redactor {
+	return defaultRedactor.Load().(*Redactor)
+}
+
+// SetRedactor replace the default redactor
+func SetRedactor(r *Redactor) {
+	defaultRedactor.Store(r)
+}
+
+// WithRedaction zap option masking the fields and messages of a logger
+func WithRedaction(r *Redactor) zap.Option {
+	return zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &RedactCore{Core: core, r: r}
+	})
+}
+
+// IsSensitive report whether key names sensitive data
+func (r *Redactor) IsSensitive(key string) bool {
+	key = normalizeKey(key)
+	for _, k := range r.keys {
+		if key == k || strings.HasSuffix(key, "_"+k) {
+			return true
+		}
+	}
+	return false
+}
+
+// String mask the pattern matches of s
+func (r *Redactor) String(s string) string {
+	for _, re := range r.patterns {
+		s = re.ReplaceAllString(s, r.mask)
+	}
+	return s
+}
+
+// Query mask the values of sensitive params of a raw query string
+func (r *Redactor) Query(rawQuery string) string {
+	if rawQuery == "" {
+		return rawQuery
+	}
+	params := strings.Split(rawQuery, "&")
+	for i, p := range params {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, err := url.QueryUnescape(kv[0])
+		if err != nil {
+			key = kv[0]
+		}
+		if r.IsSensitive(key) {
+			params[i] = kv[0] + "=" + r.mask
+		}
+	}
+	return r.String(strings.Join(params, "&"))
+}
+
+// SQL mask the string literals compared to or inserted into sensitive columns
+func (r *Redactor) SQL(sql string) string {
+	if m := sqlInsertRe.FindStringSubmatch(sql); m != nil {
+		sql = m[1] + r.insertValues(strings.Split(m[2], ","), m[3])
+	}
+	sql = sqlCompareRe.ReplaceAllStringFunc(sql, func(s string) string {
+		m := sqlCompareRe.FindStringSubmatch(s)
+		if !r.IsSensitive(columnName(m[1])) {
+			return s
+		}
+		return m[1] + m[2] + "'" + r.mask + "'"
+	})
+	return r.String(sql)
+}
+
+// insertValues mask the values of sensitive columns in the VALUES tuples
+func (r *Redactor) insertValues(columns []string, values string) string {
+	masked := make([]bool, len(columns))
+	found := false
+	for i, c := range columns {
+		masked[i] = r.IsSensitive(columnName(c))
+		found = found || masked[i]
+	}
+	if !found {
+		return values
+	}
+	var b strings.Builder
+	// last is the end of the text copied to b
+	depth, col, last := 0, 0, 0
+	inQuote := false
+	for i := 0; i < len(values); i++ {
+		ch := values[i]
+		if inQuote {
+			if ch == '\'' {
+				if i+1 < len(values) && values[i+1] == '\'' {
+					i++
+				} else {
+					inQuote = false
+				}
+			}
+			continue
+		}
+		switch {
+		case ch == '\'':
+			inQuote = true
+		case ch == '(':
+			depth++
+			if depth == 1 {
+				b.WriteString(values[last : i+1])
+				last, col = i+1, 0
+			}
+		case (ch == ',' || ch == ')') && depth == 1:
+			value := values[last:i]
+			if col < len(masked) && masked[col] {
+				if lit := strings.TrimSpace(value); strings.HasPrefix(lit, "'") {
+					value = strings.Replace(value, lit, "'"+r.mask+"'", 1)
+				}
+			}
+			b.WriteString(value)
+			b.WriteByte(ch)
+			last = i + 1
+			if ch == ',' {
+				col++
+			} else {
+				depth--
+			}
+		case ch == ')':
+			depth--
+		}
+	}
+	b.WriteString(values[last:])
+	return b.String()
+}
+
+// field return the masked field and whether it changed, a sensitive key is masked whatever the field type
+func (r *Redactor) field(f zapcore.Field) (zapcore.Field, bool) {
+	switch f.Type {
+	case zapcore.NamespaceType, zapcore.SkipType:
+		return f, false
+	}
+	if r.IsSensitive(f.Key) {
+		return zap.String(f.Key, r.mask), true
+	}
+	if f.Type == zapcore.StringType {
+		if s := r.String(f.String); s != f.String {
+			return zap.String(f.Key, s), true
+		}
+	}
+	return f, false
+}
+
+func (r *Redactor) fields(fields []zapcore.Field) []zapcore.Field {
+	var out []zapcore.Field
+	for i := range fields {
+		f, changed := r.field(fields[i])
+		if changed && out == nil {
+			out = make([]zapcore.Field, len(fields))
+			copy(out, fields)
+		}
+		if changed {
+			out[i] = f
+		}
+	}
+	if out == nil {
+		return fields
+	}
+	return out
+}
+
+// RedactCore a zapcore.Core masking sensitive fields and pattern matches before encoding
+type RedactCore struct {
+	zapcore.Core
+	r *Redactor
+}
+
+func (c *RedactCore) With(fields []zapcore.Field) zapcore.Core {
+	return &RedactCore{Core: c.Core.With(c.r.fields(fields)), r: c.r}
+}
+
+func (c *RedactCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *RedactCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	ent.Message = c.r.String(ent.Message)
+	return writeEntry(c.Core, ent, c.r.fields(fields))
+}
+
+func normalizeKey(key string) string {
+	return strings.ToLower(strings.NewReplacer("-", "_", " ", "_").Replace(strings.TrimSpace(key)))
+}
+
+// columnName strip the quotes and the table of a sql column
+func columnName(s string) string {
+	s = strings.Trim(strings.TrimSpace(s), "`\"")
+	if i := strings.LastIndex(s, "."); i >= 0 {
+		s = strings.Trim(s[i+1:], "`\"")
+	}
+	return s
+}
@@ -0,0 +1,230 @@
+package logger
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	defaultSamplingInterval = time.Second
+	defaultSamplingFirst    = 100
+	// maxSamplingKeys bounds the accounting of an interval, entries of new keys pass through once reached
+	maxSamplingKeys = 4096
+)
+
+// SamplingConfig log the first First entries of each level and message per Interval,
+// then every Thereafter-th one, 0 drops all of them
+type SamplingConfig struct {
+	// Interval default 1s
+	Interval time.Duration
+	// First default 100
+	First int
+	// Thereafter 0 drops every entry after First
+	Thereafter int
+}
+
+type samplingKey struct {
+	level zapcore.Level
+	msg   string
+}
+
+type samplingCounter struct {
+	n       uint64
+	dropped uint64
+}
+
+type sampler struct {
+	mu       sync.Mutex
+	root     zapcore.Core
+	now      func() time.Time
+	interval time.Duration
+	first    uint64
+	after    uint64
+	resetAt  time.Time
+	counters map[samplingKey]*samplingCounter
+	// running the flush goroutine runs, it stops after an interval without entries or on Close
+	running bool
+	closed  bool
+	stopCh  chan struct{}
+}
+
+// SamplingCore a zapcore.Core sampling entries by level and message,
+// a warn summary of the suppressed entries is written after each interval, on Sync and on Close
+type SamplingCore struct {
+	zapcore.Core
+	s *sampler
+}
+
+// NewSamplingCore wrap core to sample its entries
+func NewSamplingCore(core zapcore.Core, c *SamplingConfig) *SamplingCore {
+	interval := c.Interval
+	if interval <= 0 {
+		interval = defaultSamplingInterval
+	}
+	first := c.First
+	if first <= 0 {
+		first = defaultSamplingFirst
+	}
+	thereafter := c.Thereafter
+	if thereafter < 0 {
+		thereafter = 0
+	}
+	return &SamplingCore{
+		Core: core,
+		s: &sampler{
+			root:     core,
+			now:      time.Now,
+			interval: interval,
+			first:    uint64(first),
+			after:    uint64(thereafter),
+			counters: make(map[samplingKey]*samplingCounter),
+			stopCh:   make(chan struct{}),
+		},
+	}
+}
+
+// WithSampling zap option sampling the entries of a logger, e.g. SetUp(level, "", ConsoleEncoder, WithSampling(c))
+func WithSampling(c *SamplingConfig) zap.Option {
+	return zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return NewSamplingCore(core, c)
+	})
+}
+
+func (c *SamplingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &SamplingCore{Core: c.Core.With(fields), s: c.s}
+}
+
+func (c *SamplingCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.Enabled(ent.Level) {
+		return ce
+	}
+	if !c.s.sample(ent) {
+		return ce
+	}
+	return c.Core.Check(ent, ce)
+}
+
+// Sync write the pending summary then sync the wrapped core
+func (c *SamplingCore) Sync() error {
+	c.s.mu.Lock()
+	summary := c.s.drain()
+	c.s.mu.Unlock()
+	c.s.writeSummary(summary)
+	return c.Core.Sync()
+}
+
+// Close stop the flush goroutine and write the pending summary, later summaries are written on Sync
+func (c *SamplingCore) Close() error {
+	c.s.mu.Lock()
+	if c.s.closed {
+		c.s.mu.Unlock()
+		return nil
+	}
+	c.s.closed = true
+	close(c.s.stopCh)
+	summary := c.s.drain()
+	c.s.mu.Unlock()
+	c.s.writeSummary(summary)
+	return nil
+}
+
+// run write the summary after each interval until an interval without entries or Close
+func (s *sampler) run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			if len(s.counters) == 0 {
+				s.running = false
+				s.mu.Unlock()
+				return
+			}
+			summary := s.reset(s.now())
+			s.mu.Unlock()
+			s.writeSummary(summary)
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+func (s *sampler) sample(ent zapcore.Entry) bool {
+	s.mu.Lock()
+	var summary map[samplingKey]uint64
+	if t := ent.Time; !t.Before(s.resetAt) {
+		summary = s.reset(t)
+	}
+	key := samplingKey{level: ent.Level, msg: ent.Message}
+	counter, ok := s.counters[key]
+	if !ok {
+		if len(s.counters) >= maxSamplingKeys {
+			s.mu.Unlock()
+			s.writeSummary(summary)
+			return true
+		}
+		counter = &samplingCounter{}
+		s.counters[key] = counter
+	}
+	if !s.running && !s.closed {
+		s.running = true
+		go s.run()
+	}
+	counter.n++
+	n := counter.n
+	keep := n <= s.first || (s.after > 0 && (n-s.first)%s.after == 0)
+	if !keep {
+		counter.dropped++
+	}
+	s.mu.Unlock()
+	s.writeSummary(summary)
+	return keep
+}
+
+// reset start a new interval at t and return the dropped counts of the previous one, must hold mu
+func (s *sampler) reset(t time.Time) map[samplingKey]uint64 {
+	summary := s.drain()
+	s.counters = make(map[samplingKey]*samplingCounter)
+	s.resetAt = t.Add(s.interval)
+	return summary
+}
+
+// drain return and clear the dropped counts, must hold mu
+func (s *sampler) drain() map[samplingKey]uint64 {
+	var summary map[samplingKey]uint64
+	for k, c := range s.counters {
+		if c.dropped > 0 {
+			if summary == nil {
+				summary = make(map[samplingKey]uint64)
+			}
+			summary[k] = c.dropped
+			c.dropped = 0
+		}
+	}
+	return summary
+}
+
+func (s *sampler) writeSummary(summary map[samplingKey]uint64) {
+	if len(summary) == 0 || !s.root.Enabled(zapcore.WarnLevel) {
+		return
+	}
+	var total uint64
+	messages := make(map[string]uint64, len(summary))
+	for k, n := range summary {
+		total += n
+		messages[k.level.String()+": "+k.msg] = n
+	}
+	ent := zapcore.Entry{
+		Level:   zapcore.WarnLevel,
+		Time:    s.now(),
+		Message: "log sampling suppressed entries",
+	}
+	_ = writeEntry(s.root, ent, []zapcore.Field{
+		zap.Uint64("suppressed", total),
+		zap.Any("messages", messages),
+	})
+}
@@ -0,0 +1,48 @@
+package logger
+
+import (
+	"io"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	defaultMaxSize = 100 // megabytes
+	defaultMaxAge  = 14  // days
+)
+
+// FileConfig file output rotation config
+type FileConfig struct {
+	Filename string
+	// MaxSize megabytes before rotation, default 100
+	MaxSize int `mapstructure:"max_size"`
+	// MaxBackups rotated files to keep, 0 keeps all
+	MaxBackups int `mapstructure:"max_backups"`
+	// MaxAge days to keep rotated files, default 14, negative keeps forever
+	MaxAge int `mapstructure:"max_age"`
+	// Compress gzip rotated files
+	Compress bool
+	// LocalTime use local time in backup names instead of UTC
+	LocalTime bool `mapstructure:"local_time"`
+}
+
+func (fc *FileConfig) writer(filename string) io.Writer {
+	maxSize := fc.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxSize
+	}
+	maxAge := fc.MaxAge
+	if maxAge == 0 {
+		maxAge = defaultMaxAge
+	} else if maxAge < 0 {
+		maxAge = 0
+	}
+	return &lumberjack.Logger{
+		Filename:   filename,
+		MaxSize:    maxSize,
+		MaxBackups: fc.MaxBackups,
+		MaxAge:     maxAge,
+		Compress:   fc.Compress,
+		LocalTime:  fc.LocalTime,
+	}
+}
@@ -1,9 +1,9 @@
 package rabbitmq
 
 import (
+	"context"
 	"time"
 
-	"github.com/happyxhw/gopkg/logger"
 	"github.com/streadway/amqp"
 	"go.uber.org/zap"
 )
@@ -93,7 +93,7 @@ func (c *Consumer) Start(handler func(delivery amqp.Delivery)) error {
 		case m := <-msgs:
 			handler(m)
 		case <-c.closeCh:
-			logger.Error("channel close")
+			mqLogger.Error("channel close")
 			return CloseErr
 		case <-c.doneCh:
 			return nil
@@ -101,6 +101,13 @@ func (c *Consumer) Start(handler func(delivery amqp.Delivery)) error {
 	}
 }
 
+// StartContext like Start, handler receives a copy of ctx carrying the logger.ContextInfo of the delivery
+func (c *Consumer) StartContext(ctx context.Context, handler func(context.Context, amqp.Delivery)) error {
+	return c.Start(func(d amqp.Delivery) {
+		handler(ContextFromDelivery(ctx, &d), d)
+	})
+}
+
 func (c *Consumer) Close() {
 	c.doneCh <- struct{}{}
 	_ = c.channel.Close()
@@ -110,7 +117,7 @@ func (c *Consumer) Close() {
 func (c *Consumer) Reconnect() error {
 	var err error
 	for i := 0; i < c.retry; i++ {
-		logger.Info("reconnecting", zap.Int("retry", i+1))
+		mqLogger.Info("reconnecting", zap.Int("retry", i+1))
 		time.Sleep(time.Second * time.Duration(c.initRetryInterval*(i+1)))
 		_ = c.channel.Close()
 		_ = c.conn.Close()
@@ -121,7 +128,7 @@ func (c *Consumer) Reconnect() error {
 		c.channel, err = c.conn.Channel()
 		c.closeCh = make(chan *amqp.Error)
 		c.channel.NotifyClose(c.closeCh)
-		logger.Info("reconnect successful")
+		mqLogger.Info("reconnect successful")
 		break
 	}
 	return err
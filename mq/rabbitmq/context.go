@@ -0,0 +1,28 @@
+package rabbitmq
+
+import (
+	"context"
+
+	"github.com/happyxhw/gopkg/logger"
+	"github.com/streadway/amqp"
+)
+
+// InjectHeaders return a copy of headers with the logger.ContextInfo of ctx
+func InjectHeaders(ctx context.Context, headers amqp.Table) amqp.Table {
+	table := make(amqp.Table, len(headers))
+	for k, v := range headers {
+		table[k] = v
+	}
+	logger.Inject(ctx, func(key, value string) {
+		table[key] = value
+	})
+	return table
+}
+
+// ContextFromDelivery return a copy of ctx carrying the request and trace ids of the delivery headers, see logger.Extract
+func ContextFromDelivery(ctx context.Context, d *amqp.Delivery) context.Context {
+	return logger.Extract(ctx, func(key string) string {
+		v, _ := d.Headers[key].(string)
+		return v
+	})
+}
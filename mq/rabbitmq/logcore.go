@@ -0,0 +1,448 @@
+package rabbitmq
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/happyxhw/gopkg/logger"
+	"github.com/streadway/amqp"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	defaultLogRoutingKey        = "logs.{level}"
+	defaultLogBatchSize         = 100
+	defaultLogQueueSize         = 8192
+	defaultLogFlushInterval     = time.Second
+	defaultLogReconnectInterval = 5 * time.Second
+	defaultLogPublishTimeout    = 5 * time.Second
+	defaultMaxSpoolSize         = 100 << 20
+
+	logContentType = "application/x-ndjson"
+	spoolExt       = ".spool"
+)
+
+// the entries of mqLogger are not shipped, the producer logs its own failures
+const mqLoggerName = "rabbitmq"
+
+// LogConfig log shipping config
+type LogConfig struct {
+	// RoutingKey template of the routing key, {level} and {logger} are replaced
+	// by the level and the logger name of the entry, default logs.{level}
+	RoutingKey string `mapstructure:"routing_key"`
+	// Level min level to ship, default debug
+	Level string
+	// Encoder json, console, default json
+	Encoder string
+	// BatchSize max entries per message, default 100
+	BatchSize int `mapstructure:"batch_size"`
+	// QueueSize max queued entries, new entries are dropped once full, default 8192
+	QueueSize int `mapstructure:"queue_size"`
+	// FlushInterval interval to publish the pending batches, default 1s
+	FlushInterval time.Duration `mapstructure:"flush_interval"`
+	// ReconnectInterval interval between reconnect attempts while the broker is down, default 5s
+	ReconnectInterval time.Duration `mapstructure:"reconnect_interval"`
+	// PublishTimeout max wait of the confirmation of a batch, the broker is then considered down, default 5s
+	PublishTimeout time.Duration `mapstructure:"publish_timeout"`
+	// SpoolDir directory of the batches that could not be published, empty to drop them
+	SpoolDir string `mapstructure:"spool_dir"`
+	// MaxSpoolSize max bytes of SpoolDir, batches are dropped once reached, default 100MB
+	MaxSpoolSize int64 `mapstructure:"max_spool_size"`
+}
+
+// LogStats state of a LogCore
+type LogStats struct {
+	Queued    int    // number of entries waiting to be batched
+	Published uint64 // number of published entries
+	Spooled   uint64 // number of entries written to the spool
+	Dropped   uint64 // number of dropped entries: queue full, unroutable or spool full
+}
+
+// publisher is implemented by *Producer
+type publisher interface {
+	PublishContext(ctx context.Context, msg *amqp.Publishing, key string) (*amqp.Return, error)
+	Reconnect() error
+}
+
+type logRecord struct {
+	key  string
+	line []byte
+	// done is set for a flush request
+	done chan struct{}
+}
+
+type logBatch struct {
+	buf bytes.Buffer
+	n   int
+}
+
+type logShipper struct {
+	mu     sync.RWMutex
+	closed bool
+
+	p        publisher
+	c        LogConfig
+	ch       chan logRecord
+	batches  map[string]*logBatch
+	down     bool
+	retryAt  time.Time
+	spoolSeq uint64
+
+	published uint64 // atomic
+	spooled   uint64 // atomic
+	dropped   uint64 // atomic
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// LogCore a zapcore.Core publishing the encoded entries in batches through a Producer,
+// one newline delimited message per routing key.
+// While the broker is down the batches are spooled to disk and replayed once reconnected,
+// the spool left by a previous process is replayed at start.
+// The producer must not be used by anything else, e.g.
+//
+//	p, _ := rabbitmq.NewProducer(url, "logs", amqp.ExchangeTopic)
+//	core, _ := rabbitmq.NewLogCore(p, &rabbitmq.LogConfig{SpoolDir: "/var/spool/app"})
+//	_ = logger.Init(&logger.Config{Cores: []zapcore.Core{core}})
+type LogCore struct {
+	zapcore.LevelEnabler
+	enc zapcore.Encoder
+	s   *logShipper
+}
+
+// NewLogCore create a LogCore publishing through p
+func NewLogCore(p *Producer, c *LogConfig) (*LogCore, error) {
+	return newLogCore(p, c)
+}
+
+func newLogCore(p publisher, c *LogConfig) (*LogCore, error) {
+	conf := *c
+	level, err := logger.ParseLevel(conf.Level)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Level == "" {
+		level = zapcore.DebugLevel
+	}
+	if conf.Encoder == "" {
+		conf.Encoder = "json"
+	}
+	encoderType, err := logger.ParseEncoder(conf.Encoder)
+	if err != nil {
+		return nil, err
+	}
+	if conf.RoutingKey == "" {
+		conf.RoutingKey = defaultLogRoutingKey
+	}
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = defaultLogBatchSize
+	}
+	if conf.QueueSize <= 0 {
+		conf.QueueSize = defaultLogQueueSize
+	}
+	if conf.FlushInterval <= 0 {
+		conf.FlushInterval = defaultLogFlushInterval
+	}
+	if conf.ReconnectInterval <= 0 {
+		conf.ReconnectInterval = defaultLogReconnectInterval
+	}
+	if conf.PublishTimeout <= 0 {
+		conf.PublishTimeout = defaultLogPublishTimeout
+	}
+	if conf.MaxSpoolSize <= 0 {
+		conf.MaxSpoolSize = defaultMaxSpoolSize
+	}
+	if conf.SpoolDir != "" {
+		if err := os.MkdirAll(conf.SpoolDir, 0700); err != nil {
+			return nil, fmt.Errorf("rabbitmq: spool dir: %w", err)
+		}
+	}
+	s := &logShipper{
+		p:       p,
+		c:       conf,
+		ch:      make(chan logRecord, conf.QueueSize),
+		batches: make(map[string]*logBatch),
+		stopCh:  make(chan struct{}),
+		doneCh:  make(chan struct{}),
+	}
+	go s.run()
+	return &LogCore{LevelEnabler: level, enc: logger.NewEncoder(encoderType), s: s}, nil
+}
+
+func (c *LogCore) With(fields []zapcore.Field) zapcore.Core {
+	enc := c.enc.Clone()
+	for i := range fields {
+		fields[i].AddTo(enc)
+	}
+	return &LogCore{LevelEnabler: c.LevelEnabler, enc: enc, s: c.s}
+}
+
+func (c *LogCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) && !isMQLogger(ent.LoggerName) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *LogCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return err
+	}
+	r := logRecord{key: c.s.routingKey(ent), line: append([]byte(nil), buf.Bytes()...)}
+	buf.Free()
+	c.s.mu.RLock()
+	defer c.s.mu.RUnlock()
+	if c.s.closed {
+		atomic.AddUint64(&c.s.dropped, 1)
+		return nil
+	}
+	// never block the caller on the broker
+	select {
+	case c.s.ch <- r:
+	default:
+		atomic.AddUint64(&c.s.dropped, 1)
+	}
+	return nil
+}
+
+// Sync publish or spool the queued entries
+func (c *LogCore) Sync() error {
+	c.s.mu.RLock()
+	if c.s.closed {
+		c.s.mu.RUnlock()
+		return nil
+	}
+	done := make(chan struct{})
+	c.s.ch <- logRecord{done: done}
+	c.s.mu.RUnlock()
+	<-done
+	return nil
+}
+
+// Close publish or spool the queued entries and stop the background goroutine,
+// later entries are dropped, the producer is not closed
+func (c *LogCore) Close() error {
+	c.s.mu.Lock()
+	if c.s.closed {
+		c.s.mu.Unlock()
+		return nil
+	}
+	c.s.closed = true
+	c.s.mu.Unlock()
+	close(c.s.stopCh)
+	<-c.s.doneCh
+	return nil
+}
+
+// Stats return the shipping counters
+func (c *LogCore) Stats() LogStats {
+	return LogStats{
+		Queued:    len(c.s.ch),
+		Published: atomic.LoadUint64(&c.s.published),
+		Spooled:   atomic.LoadUint64(&c.s.spooled),
+		Dropped:   atomic.LoadUint64(&c.s.dropped),
+	}
+}
+
+func isMQLogger(name string) bool {
+	return name == mqLoggerName || strings.HasPrefix(name, mqLoggerName+".")
+}
+
+func (s *logShipper) routingKey(ent zapcore.Entry) string {
+	name := ent.LoggerName
+	if name == "" {
+		name = "root"
+	}
+	return strings.NewReplacer("{level}", ent.Level.String(), "{logger}", name).Replace(s.c.RoutingKey)
+}
+
+func (s *logShipper) run() {
+	defer close(s.doneCh)
+	ticker := time.NewTicker(s.c.FlushInterval)
+	defer ticker.Stop()
+	s.replay()
+	for {
+		select {
+		case r := <-s.ch:
+			s.handle(r)
+		case <-ticker.C:
+			s.flush()
+			s.reconnect()
+		case <-s.stopCh:
+			// no writer can enqueue once closed, drain what is left
+			for {
+				select {
+				case r := <-s.ch:
+					s.handle(r)
+				default:
+					s.flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *logShipper) handle(r logRecord) {
+	if r.done != nil {
+		s.flush()
+		close(r.done)
+		return
+	}
+	b, ok := s.batches[r.key]
+	if !ok {
+		b = &logBatch{}
+		s.batches[r.key] = b
+	}
+	b.buf.Write(r.line)
+	b.n++
+	if b.n >= s.c.BatchSize {
+		s.send(r.key, b.buf.Bytes(), b.n)
+		delete(s.batches, r.key)
+	}
+}
+
+// flush send every pending batch
+func (s *logShipper) flush() {
+	for key, b := range s.batches {
+		s.send(key, b.buf.Bytes(), b.n)
+	}
+	s.batches = make(map[string]*logBatch)
+}
+
+// send publish a batch of n entries, spool it if the broker is down
+func (s *logShipper) send(key string, body []byte, n int) {
+	if !s.down {
+		err := s.publish(key, body)
+		if err == nil {
+			atomic.AddUint64(&s.published, uint64(n))
+			return
+		}
+		if errors.Is(err, ReturnErr) {
+			atomic.AddUint64(&s.dropped, uint64(n))
+			return
+		}
+		s.setDown()
+	}
+	if err := s.spool(key, body); err != nil {
+		atomic.AddUint64(&s.dropped, uint64(n))
+		return
+	}
+	atomic.AddUint64(&s.spooled, uint64(n))
+}
+
+func (s *logShipper) publish(key string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.c.PublishTimeout)
+	defer cancel()
+	_, err := s.p.PublishContext(ctx, &amqp.Publishing{
+		ContentType:  logContentType,
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    time.Now(),
+		Body:         body,
+	}, key)
+	return err
+}
+
+func (s *logShipper) setDown() {
+	s.down = true
+	s.retryAt = time.Now().Add(s.c.ReconnectInterval)
+}
+
+// reconnect try to reconnect once ReconnectInterval elapsed, then replay the spool
+func (s *logShipper) reconnect() {
+	if !s.down || time.Now().Before(s.retryAt) {
+		return
+	}
+	if err := s.p.Reconnect(); err != nil {
+		s.setDown()
+		return
+	}
+	s.down = false
+	s.replay()
+}
+
+// spool write a batch to a new file of SpoolDir, the first line is the routing key
+func (s *logShipper) spool(key string, body []byte) error {
+	if s.c.SpoolDir == "" {
+		return errors.New("rabbitmq: no spool dir")
+	}
+	files, size, err := s.spoolFiles()
+	if err != nil {
+		return err
+	}
+	if size+int64(len(key)+1+len(body)) > s.c.MaxSpoolSize {
+		return fmt.Errorf("rabbitmq: spool is full, %d files", len(files))
+	}
+	s.spoolSeq++
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), s.spoolSeq, spoolExt)
+	data := make([]byte, 0, len(key)+1+len(body))
+	data = append(append(append(data, key...), '\n'), body...)
+	return ioutil.WriteFile(filepath.Join(s.c.SpoolDir, name), data, 0600)
+}
+
+// spoolFiles return the spool files in write order and their total size
+func (s *logShipper) spoolFiles() ([]string, int64, error) {
+	infos, err := ioutil.ReadDir(s.c.SpoolDir)
+	if err != nil {
+		return nil, 0, err
+	}
+	var files []string
+	var size int64
+	for _, info := range infos {
+		if info.IsDir() || filepath.Ext(info.Name()) != spoolExt {
+			continue
+		}
+		files = append(files, filepath.Join(s.c.SpoolDir, info.Name()))
+		size += info.Size()
+	}
+	sort.Strings(files)
+	return files, size, nil
+}
+
+// replay publish and remove the spool files in write order, stop at the first failure
+func (s *logShipper) replay() {
+	if s.c.SpoolDir == "" || s.down {
+		return
+	}
+	files, _, err := s.spoolFiles()
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			// not a complete spool file
+			_ = os.Remove(file)
+			continue
+		}
+		key, body := string(data[:i]), data[i+1:]
+		n := bytes.Count(body, []byte{'\n'})
+		err = s.publish(key, body)
+		if err != nil && !errors.Is(err, ReturnErr) {
+			s.setDown()
+			return
+		}
+		if err != nil {
+			atomic.AddUint64(&s.dropped, uint64(n))
+		} else {
+			atomic.AddUint64(&s.published, uint64(n))
+		}
+		_ = os.Remove(file)
+	}
+}
@@ -0,0 +1,147 @@
+package rabbitmq
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"go.uber.org/zap"
+)
+
+type fakePublisher struct {
+	mu   sync.Mutex
+	up   bool
+	hang bool
+	msgs map[string][]string
+}
+
+func (f *fakePublisher) PublishContext(ctx context.Context, msg *amqp.Publishing, key string) (*amqp.Return, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.hang {
+		// the broker never confirms
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if !f.up {
+		return nil, CloseErr
+	}
+	for _, line := range bytes.Split(bytes.TrimSpace(msg.Body), []byte{'\n'}) {
+		f.msgs[key] = append(f.msgs[key], string(line))
+	}
+	return nil, nil
+}
+
+func (f *fakePublisher) Reconnect() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.up {
+		return CloseErr
+	}
+	return nil
+}
+
+func (f *fakePublisher) setUp(up bool) {
+	f.mu.Lock()
+	f.up = up
+	f.mu.Unlock()
+}
+
+func (f *fakePublisher) count(key string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.msgs[key])
+}
+
+func TestLogCore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logcore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := &fakePublisher{up: true, msgs: make(map[string][]string)}
+	core, err := newLogCore(p, &LogConfig{
+		RoutingKey:        "logs.{logger}.{level}",
+		FlushInterval:     10 * time.Millisecond,
+		ReconnectInterval: 10 * time.Millisecond,
+		SpoolDir:          dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer core.Close()
+	l := zap.New(core)
+
+	l.Named("app").Info("hello")
+	l.Named("app").Info("world")
+	l.Warn("root")
+	l.Named("rabbitmq").Error("not shipped")
+	_ = l.Sync()
+	if n := p.count("logs.app.info"); n != 2 {
+		t.Fatalf("logs.app.info: %d messages", n)
+	}
+	if n := p.count("logs.root.warn"); n != 1 {
+		t.Fatalf("logs.root.warn: %d messages", n)
+	}
+
+	// broker down, entries are spooled
+	p.setUp(false)
+	l.Named("app").Info("spooled")
+	_ = l.Sync()
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Fatalf("%d spool files", len(files))
+	}
+
+	// broker up, the spool is replayed
+	p.setUp(true)
+	deadline := time.Now().Add(time.Second)
+	for p.count("logs.app.info") != 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := p.count("logs.app.info"); n != 3 {
+		t.Fatalf("logs.app.info: %d messages after replay", n)
+	}
+	files, _ = ioutil.ReadDir(dir)
+	if len(files) != 0 {
+		t.Fatalf("%d spool files after replay", len(files))
+	}
+	s := core.Stats()
+	if s.Published != 4 || s.Spooled != 1 || s.Dropped != 0 {
+		t.Fatalf("stats %+v", s)
+	}
+}
+
+func TestLogCorePublishTimeout(t *testing.T) {
+	p := &fakePublisher{hang: true, msgs: make(map[string][]string)}
+	core, err := newLogCore(p, &LogConfig{
+		FlushInterval:     time.Hour,
+		ReconnectInterval: time.Hour,
+		PublishTimeout:    20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer core.Close()
+	l := zap.New(core)
+
+	l.Info("unconfirmed")
+	done := make(chan struct{})
+	go func() {
+		_ = l.Sync()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("sync blocked by an unconfirmed publish")
+	}
+	if s := core.Stats(); s.Dropped != 1 {
+		t.Errorf("stats %+v", s)
+	}
+}
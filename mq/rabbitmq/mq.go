@@ -2,8 +2,12 @@ package rabbitmq
 
 import (
 	"errors"
+
+	"github.com/happyxhw/gopkg/logger"
 )
 
+var mqLogger = logger.Named("rabbitmq")
+
 var (
 	ReturnErr  = errors.New("return err")
 	NAckErr    = errors.New("nack")
@@ -1,6 +1,8 @@
 package rabbitmq
 
 import (
+	"context"
+
 	"github.com/happyxhw/gopkg/logger"
 	"github.com/streadway/amqp"
 	"go.uber.org/zap"
@@ -25,27 +27,34 @@ func NewProducer(url, exName, exType string) (*Producer, error) {
 		url:          url,
 		exchangeName: exName,
 		exchangeType: exType,
-
-		ackCh:    make(chan uint64),
-		nAckCh:   make(chan uint64),
-		returnCh: make(chan amqp.Return, 1),
 	}
+	if err := p.connect(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (p *Producer) connect() error {
 	var err error
-	p.conn, err = amqp.Dial(url)
+	p.conn, err = amqp.Dial(p.url)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	p.channel, err = p.conn.Channel()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	err = p.channel.Confirm(false)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	// the notify channels are closed with the amqp channel, create them on each connection
+	p.ackCh = make(chan uint64)
+	p.nAckCh = make(chan uint64)
+	p.returnCh = make(chan amqp.Return, 1)
 	p.channel.NotifyConfirm(p.ackCh, p.nAckCh)
 	p.channel.NotifyReturn(p.returnCh)
-	err = p.channel.ExchangeDeclare(
+	return p.channel.ExchangeDeclare(
 		p.exchangeName,
 		p.exchangeType,
 		true,
@@ -54,31 +63,64 @@ func NewProducer(url, exName, exType string) (*Producer, error) {
 		false,
 		nil,
 	)
+}
+
+// Reconnect close the current connection and dial again
+func (p *Producer) Reconnect() error {
+	if p.channel != nil {
+		_ = p.channel.Close()
+	}
+	if p.conn != nil {
+		_ = p.conn.Close()
+	}
+	err := p.connect()
 	if err != nil {
-		return nil, err
+		mqLogger.Error("producer reconnect", zap.Error(err))
+		return err
 	}
-	return &p, nil
+	mqLogger.Info("producer reconnect successful")
+	return nil
 }
 
 func (p *Producer) Publish(msg *amqp.Publishing, key string) (*amqp.Return, error) {
+	return p.PublishContext(context.Background(), msg, key)
+}
+
+// PublishContext publish msg with the logger.ContextInfo of ctx in its headers and wait for its confirmation
+// until ctx is done, then the producer should be reconnected as a late confirmation would answer the next publish
+func (p *Producer) PublishContext(ctx context.Context, msg *amqp.Publishing, key string) (*amqp.Return, error) {
+	m := *msg
+	m.Headers = InjectHeaders(ctx, msg.Headers)
 	err := p.channel.Publish(
 		p.exchangeName,
 		key,
 		true,
 		false,
-		*msg,
+		m,
 	)
 	if err != nil {
-		logger.Error("publish", zap.Error(err))
+		mqLogger.With(logger.ContextFields(ctx)...).Error("publish", zap.Error(err))
 		return nil, err
 	}
+	// the notify channels are closed if the connection is lost before the confirmation
 	select {
-	case r := <-p.returnCh:
+	case r, ok := <-p.returnCh:
+		if !ok {
+			return nil, CloseErr
+		}
 		return &r, ReturnErr
-	case <-p.ackCh:
+	case _, ok := <-p.ackCh:
+		if !ok {
+			return nil, CloseErr
+		}
 		return nil, nil
-	case <-p.nAckCh:
+	case _, ok := <-p.nAckCh:
+		if !ok {
+			return nil, CloseErr
+		}
 		return nil, NAckErr
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 }
 